@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// sampleRing is a lock-free ring buffer of samples between one producer and
+// one consumer goroutine. The read and write positions only ever grow; the
+// producer only moves the write position and the consumer only the read
+// position.
+type sampleRing struct {
+	buf   []int16
+	mask  uint64
+	read  atomic.Uint64
+	write atomic.Uint64
+}
+
+// newSampleRing returns a ring holding at least size samples.
+func newSampleRing(size int) *sampleRing {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+	return &sampleRing{buf: make([]int16, n), mask: uint64(n - 1)}
+}
+
+// available returns the number of samples that can be read.
+func (r *sampleRing) available() int {
+	return int(r.write.Load() - r.read.Load())
+}
+
+// free returns the number of samples that can be written.
+func (r *sampleRing) free() int {
+	return len(r.buf) - r.available()
+}
+
+// put writes as many samples as fit, and returns their number. Only the
+// producer calls put.
+func (r *sampleRing) put(samples []int16) int {
+	w := r.write.Load()
+	n := min(len(samples), len(r.buf)-int(w-r.read.Load()))
+	for i := 0; i < n; i++ {
+		r.buf[(w+uint64(i))&r.mask] = samples[i]
+	}
+	r.write.Store(w + uint64(n))
+	return n
+}
+
+// get reads as many samples as are available, and returns their number.
+// Only the consumer calls get.
+func (r *sampleRing) get(out []int16) int {
+	rd := r.read.Load()
+	n := min(len(out), int(r.write.Load()-rd))
+	for i := 0; i < n; i++ {
+		out[i] = r.buf[(rd+uint64(i))&r.mask]
+	}
+	r.read.Store(rd + uint64(n))
+	return n
+}
+
+// audioStream runs the emulation in a producer goroutine, keeping a ring
+// buffer filled a given latency ahead of the audio device, which reads the
+// samples from its own thread.
+type audioStream struct {
+	player  *SidPlayer
+	ring    *sampleRing
+	latency int
+	chunk   []int16
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	// Reads that found too few samples, and the samples of silence played
+	// instead.
+	underruns atomic.Uint64
+	missing   atomic.Uint64
+}
+
+// newAudioStream buffers latency of audio at the sample rate. The device
+// reads up to deviceSamples at a time.
+func newAudioStream(player *SidPlayer, sampleRate uint32, latency time.Duration, deviceSamples int) *audioStream {
+	samples := max(int(latency.Seconds()*float64(sampleRate)), deviceSamples)
+	return &audioStream{
+		player:  player,
+		ring:    newSampleRing(samples + deviceSamples),
+		latency: samples,
+		chunk:   make([]int16, 512),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// start fills the buffer and starts the producer.
+func (a *audioStream) start() {
+	a.fill()
+	go a.produce()
+}
+
+// close stops the producer.
+func (a *audioStream) close() {
+	close(a.stop)
+	<-a.done
+}
+
+func (a *audioStream) fill() {
+	for a.ring.available() < a.latency {
+		n := min(len(a.chunk), a.ring.free())
+		a.player.Render(a.chunk[:n])
+		a.ring.put(a.chunk[:n])
+	}
+}
+
+func (a *audioStream) produce() {
+	defer close(a.done)
+
+	// Poll as well, in case a wake up is missed.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	var reported uint64
+	for {
+		a.fill()
+
+		if underruns := a.underruns.Load(); underruns != reported {
+			fmt.Fprintf(messages, "Warning: audio underrun, %d so far, %d samples of silence\n", underruns, a.missing.Load())
+			reported = underruns
+		}
+
+		select {
+		case <-a.stop:
+			return
+		case <-a.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// read copies samples to out, padding with silence on an underrun, and
+// wakes up the producer. It does not block, and may be called from the
+// audio device thread.
+func (a *audioStream) read(out []int16) {
+	n := a.ring.get(out)
+	if n < len(out) {
+		clear(out[n:])
+		a.underruns.Add(1)
+		a.missing.Add(uint64(len(out) - n))
+	}
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
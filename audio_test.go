@@ -0,0 +1,92 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+// A producer and a consumer goroutine passing a counting sequence through a
+// small ring, in chunk sizes that do not divide the ring size, so that the
+// positions wrap around many times. Run with -race.
+func TestSampleRingWraparound(t *testing.T) {
+	const total = 20000
+	r := newSampleRing(50)
+	if len(r.buf) != 64 {
+		t.Fatalf("ring of %d samples, want 64", len(r.buf))
+	}
+
+	go func() {
+		chunk := make([]int16, 37)
+		for next := 0; next < total; {
+			n := min(len(chunk), total-next)
+			for i := range chunk[:n] {
+				chunk[i] = int16(next + i)
+			}
+			put := r.put(chunk[:n])
+			if put == 0 {
+				runtime.Gosched()
+			}
+			next += put
+		}
+	}()
+
+	out := make([]int16, 23)
+	for next := 0; next < total; {
+		n := r.get(out)
+		if n == 0 {
+			runtime.Gosched()
+		}
+		for i, v := range out[:n] {
+			if v != int16(next+i) {
+				t.Fatalf("sample %d = %d, want %d", next+i, v, int16(next+i))
+			}
+		}
+		next += n
+	}
+	if n := r.available(); n != 0 {
+		t.Errorf("%d samples left", n)
+	}
+}
+
+func TestSampleRingFull(t *testing.T) {
+	r := newSampleRing(8)
+	if n := r.put(make([]int16, 10)); n != 8 {
+		t.Errorf("put %d samples into a ring of 8", n)
+	}
+	if n := r.free(); n != 0 {
+		t.Errorf("%d samples free in a full ring", n)
+	}
+	if n := r.get(make([]int16, 3)); n != 3 {
+		t.Errorf("got %d samples, want 3", n)
+	}
+	if n := r.put(make([]int16, 10)); n != 3 {
+		t.Errorf("put %d samples after reading 3, want 3", n)
+	}
+}
+
+func TestAudioStreamUnderruns(t *testing.T) {
+	a := &audioStream{ring: newSampleRing(16), wake: make(chan struct{}, 1)}
+	a.ring.put([]int16{1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+
+	out := make([]int16, 8)
+	a.read(out)
+	if n := a.underruns.Load(); n != 0 {
+		t.Errorf("%d underruns with enough samples", n)
+	}
+
+	// Two samples left, the rest is silence.
+	for i := range out {
+		out[i] = -1
+	}
+	a.read(out)
+	if out[0] != 9 || out[1] != 10 || out[2] != 0 || out[7] != 0 {
+		t.Errorf("read %v, want 9, 10 and silence", out)
+	}
+	a.read(out)
+	if n, m := a.underruns.Load(), a.missing.Load(); n != 2 || m != 6+8 {
+		t.Errorf("%d underruns, %d samples missing, want 2 and 14", n, m)
+	}
+	if len(a.wake) != 1 {
+		t.Error("producer not woken up")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	resid "yaspg/app/sid"
+)
+
+// command is a playback control command read from the terminal.
+type command struct {
+	args string
+	help string
+	run  func(player *SidPlayer, args []string) error
+}
+
+var commands = map[string]command{
+	"potx": {"<value>|+<n>|-<n>", "set POTX, or move it by n", func(player *SidPlayer, args []string) error {
+		x, y := player.Paddles()
+		v, err := paddleValue(x, args)
+		if err == nil {
+			player.SetPaddles(v, y)
+		}
+		return err
+	}},
+	"poty": {"<value>|+<n>|-<n>", "set POTY, or move it by n", func(player *SidPlayer, args []string) error {
+		x, y := player.Paddles()
+		v, err := paddleValue(y, args)
+		if err == nil {
+			player.SetPaddles(x, v)
+		}
+		return err
+	}},
+	"paddles": {"<x> <y>", "set POTX and POTY", func(player *SidPlayer, args []string) error {
+		if len(args) != 2 {
+			return errors.New("expected x and y")
+		}
+		x, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return err
+		}
+		y, err := strconv.ParseUint(args[1], 0, 8)
+		if err != nil {
+			return err
+		}
+		player.SetPaddles(uint8(x), uint8(y))
+		return nil
+	}},
+	"mouse": {"<dx> <dy>", "move a 1351 mouse", func(player *SidPlayer, args []string) error {
+		if len(args) != 2 {
+			return errors.New("expected dx and dy")
+		}
+		dx, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		dy, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		player.MoveMouse(dx, dy)
+		return nil
+	}},
+	"back": {"[<seconds>]", "step back, 5 seconds by default", func(player *SidPlayer, args []string) error {
+		d := 5 * time.Second
+		if len(args) > 0 {
+			var err error
+			if d, err = parseTime(args[0]); err != nil {
+				return err
+			}
+		}
+		if err := player.Rewind(d); err != nil {
+			return err
+		}
+		fmt.Fprintf(messages, "At %s\n", formatTime(player.Elapsed()))
+		return nil
+	}},
+	"scrub": {"<time>", "go back to a time that has been played, as seconds or m:ss", func(player *SidPlayer, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected a time")
+		}
+		t, err := parseTime(args[0])
+		if err != nil {
+			return err
+		}
+		if err := player.Scrub(t); err != nil {
+			return err
+		}
+		fmt.Fprintf(messages, "At %s\n", formatTime(player.Elapsed()))
+		return nil
+	}},
+	"seek": {"<time>", "go to a time, as seconds or m:ss", func(player *SidPlayer, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected a time")
+		}
+		t, err := parseTime(args[0])
+		if err != nil {
+			return err
+		}
+		if err := player.Seek(t); err != nil {
+			return err
+		}
+		fmt.Fprintf(messages, "At %s\n", formatTime(player.Elapsed()))
+		return nil
+	}},
+	"next": {"", "play the next subtune", func(player *SidPlayer, args []string) error {
+		return player.NextTune()
+	}},
+	"prev": {"", "play the previous subtune", func(player *SidPlayer, args []string) error {
+		return player.PrevTune()
+	}},
+	"tune": {"[<n>]", "play subtune n, counting from 0, or show the current one", func(player *SidPlayer, args []string) error {
+		if len(args) == 0 {
+			num, songs := player.Tune()
+			fmt.Fprintf(messages, "Subtune %d of %d\n", num, songs)
+			return nil
+		}
+		num, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		return player.PlayTune(num)
+	}},
+	"save": {"<file>", "save a machine snapshot", func(player *SidPlayer, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected a file name")
+		}
+		return player.saveSnapshot(args[0])
+	}},
+	"load": {"<file>", "continue from a machine snapshot", func(player *SidPlayer, args []string) error {
+		if len(args) != 1 {
+			return errors.New("expected a file name")
+		}
+		return player.loadSnapshot(args[0])
+	}},
+	"state": {"", "show voice and filter state", func(player *SidPlayer, args []string) error {
+		printState(player.State())
+		return nil
+	}},
+}
+
+func printState(state resid.SidState) {
+	for i, v := range state.Voice {
+		gate := "off"
+		if v.Gate {
+			gate = "on"
+		}
+		fmt.Fprintf(messages, "  Voice %d: freq $%04x pw $%03x wave $%x gate %s ADSR %x%x%x%x %s level %d\n",
+			i+1, v.Frequency, v.PulseWidth, v.Waveform, gate,
+			v.Attack, v.Decay, v.Sustain, v.Release, v.EnvelopeState, v.EnvelopeLevel)
+	}
+	f := state.Filter
+	fmt.Fprintf(messages, "  Filter: cutoff $%03x (%d Hz) res %x routing $%x mode $%x volume %d\n",
+		f.Cutoff, f.CutoffFreq, f.Resonance, f.Routing, f.Mode, f.Volume)
+}
+
+// paddleValue returns a paddle position from an absolute value, or a value
+// relative to the current position when prefixed with + or -.
+func paddleValue(current uint8, args []string) (uint8, error) {
+	if len(args) != 1 {
+		return 0, errors.New("expected a value")
+	}
+
+	if args[0][0] == '+' || args[0][0] == '-' {
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return 0, err
+		}
+		return uint8(max(0, min(255, int(current)+n))), nil
+	}
+
+	v, err := strconv.ParseUint(args[0], 0, 8)
+	return uint8(v), err
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		c := commands[name]
+		fmt.Fprintf(messages, "  %-24s %s\n", name+" "+c.args, c.help)
+	}
+	fmt.Fprintf(messages, "  %-24s %s\n", "help", "show this help")
+	fmt.Fprintf(messages, "  %-24s %s\n", "quit, <Enter>", "stop playback")
+}
+
+// commandLoop reads commands from stdin until an empty line, quit or end
+// of input.
+func commandLoop(player *SidPlayer) {
+	fmt.Fprintln(messages, "Press the Enter Key to stop anytime, type help for commands")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "quit" || fields[0] == "q" {
+			return
+		}
+
+		if fields[0] == "help" {
+			printCommands()
+			continue
+		}
+
+		c, ok := commands[fields[0]]
+		if !ok {
+			fmt.Fprintf(messages, "Unknown command %q, type help for commands\n", fields[0])
+			continue
+		}
+		if err := c.run(player, fields[1:]); err != nil {
+			fmt.Fprintf(messages, "%s: %v\n", fields[0], err)
+		}
+	}
+}
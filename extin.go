@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	resid "yaspg/app/sid"
+)
+
+// ExternalInput streams a recording into the EXT IN pin of the SID,
+// resampled to the chip clock by linear interpolation.
+type ExternalInput struct {
+	wav *WavData
+
+	// Gain applied to the recording, and a DC level added to it, e.g. for
+	// digi boost.
+	gain   float64
+	offset int
+
+	// Position in the recording in samples, and samples per cycle.
+	pos  float64
+	step float64
+}
+
+// NewExternalInput loads a WAV file for the EXT IN pin.
+func NewExternalInput(fileName string, gain float64) (*ExternalInput, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	wav, err := ReadWav(file)
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalInput{wav: wav, gain: gain}, nil
+}
+
+// setClock sets the chip clock frequency to resample to.
+func (e *ExternalInput) setClock(clockFreq uint32) {
+	e.step = float64(e.wav.SampleRate) / float64(clockFreq)
+}
+
+// rewind restarts the recording, in sync with the start of a tune.
+func (e *ExternalInput) rewind() {
+	e.pos = 0
+}
+
+// Sample implements resid.InputSource. The recording is clipped to 16 bits,
+// and rides on the DC level, so that none of it is lost to the full scale
+// level of digi boost. The recording is silent after its end.
+func (e *ExternalInput) Sample(delta_t resid.CycleCount) int {
+	e.pos += e.step * float64(delta_t)
+
+	i := int(e.pos)
+	if i+1 >= len(e.wav.Samples) {
+		return e.offset
+	}
+	frac := e.pos - float64(i)
+	v := float64(e.wav.Samples[i])*(1-frac) + float64(e.wav.Samples[i+1])*frac
+
+	sample := max(-32768, min(32767, int(v*e.gain*32767)))
+	return sample + e.offset
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// The recording is resampled to the chip clock by linear interpolation, and
+// is silent after its end.
+func TestExternalInputResample(t *testing.T) {
+	e := &ExternalInput{
+		wav:  &WavData{SampleRate: 1000, Channels: 1, Samples: []float32{0, 0.5, -0.5, 1}},
+		gain: 1,
+	}
+	e.setClock(4000)
+
+	want := []int{4095, 8191, 12287, 16383, 8191, 0, -8191, -16383, -4095, 8191, 20479, 0, 0, 0}
+	for i, w := range want {
+		if got := e.Sample(1); got != w {
+			t.Fatalf("cycle %d: sample = %d, want %d", i+1, got, w)
+		}
+	}
+
+	// Clocking several cycles at once lands on the same point.
+	e.rewind()
+	if got := e.Sample(6); got != 0 {
+		t.Errorf("after 6 cycles: sample = %d, want 0", got)
+	}
+	if got := e.Sample(3); got != -4095 {
+		t.Errorf("after 9 cycles: sample = %d, want -4095", got)
+	}
+	if got := e.Sample(100); got != 0 {
+		t.Errorf("after the end: sample = %d, want 0", got)
+	}
+}
+
+// With digi boost, the recording rides on the full scale DC level, keeping
+// its negative half. The gain is clipped to 16 bits before the level is
+// added.
+func TestExternalInputOffset(t *testing.T) {
+	e := &ExternalInput{
+		wav:    &WavData{SampleRate: 1000, Channels: 1, Samples: []float32{-0.5, -0.5, 1, 1}},
+		gain:   2,
+		offset: DIGIBOOST_LEVEL,
+	}
+	e.setClock(1000)
+
+	if got, want := e.Sample(0), DIGIBOOST_LEVEL-32767; got != want {
+		t.Errorf("negative half: sample = %d, want %d", got, want)
+	}
+	if got, want := e.Sample(2), DIGIBOOST_LEVEL+32767; got != want {
+		t.Errorf("overdriven: sample = %d, want %d", got, want)
+	}
+	if got := e.Sample(10); got != DIGIBOOST_LEVEL {
+		t.Errorf("after the end: sample = %d, want %d", got, DIGIBOOST_LEVEL)
+	}
+}
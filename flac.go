@@ -0,0 +1,735 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// FLAC encoding parameters. Blocks are a fixed number of samples, predicted
+// by a fixed polynomial or by linear prediction of up to FLAC_MAX_LPC_ORDER
+// quantized coefficients, and the residual is Rice coded in up to
+// 2^FLAC_MAX_PARTITION_ORDER partitions.
+const (
+	FLAC_BLOCKSIZE           = 4096
+	FLAC_MAX_FIXED_ORDER     = 4
+	FLAC_MAX_LPC_ORDER       = 12
+	FLAC_LPC_PRECISION       = 15
+	FLAC_MAX_PARTITION_ORDER = 8
+	FLAC_MAX_RICE_PARAM      = 14
+	FLAC_BITS_PER_SAMPLE     = 16
+	FLAC_VENDOR              = "yaspg"
+)
+
+// Metadata block types.
+const (
+	FLAC_STREAMINFO     = 0
+	FLAC_VORBIS_COMMENT = 4
+)
+
+// Channel assignments of stereo frames, with the difference of the
+// channels on one of them.
+const (
+	FLAC_LEFT_SIDE  = 8
+	FLAC_SIDE_RIGHT = 9
+	FLAC_MID_SIDE   = 10
+)
+
+// Subframe types.
+const (
+	FLAC_SUBFRAME_CONSTANT = 0x00
+	FLAC_SUBFRAME_VERBATIM = 0x01
+	FLAC_SUBFRAME_FIXED    = 0x08
+	FLAC_SUBFRAME_LPC      = 0x20
+)
+
+// flacBits writes a bit stream, most significant bit first.
+type flacBits struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (b *flacBits) reset() {
+	b.buf = b.buf[:0]
+	b.acc, b.bits = 0, 0
+}
+
+// write writes the low n bits of v, n up to 32.
+func (b *flacBits) write(v uint64, n uint) {
+	b.acc = b.acc<<n | v&(1<<n-1)
+	b.bits += n
+	for b.bits >= 8 {
+		b.bits -= 8
+		b.buf = append(b.buf, byte(b.acc>>b.bits))
+	}
+	b.acc &= 1<<b.bits - 1
+}
+
+func (b *flacBits) writeSigned(v int64, n uint) {
+	b.write(uint64(v), n)
+}
+
+// writeUnary writes q zero bits and a one bit.
+func (b *flacBits) writeUnary(q uint64) {
+	for ; q >= 31; q -= 31 {
+		b.write(0, 31)
+	}
+	b.write(1, uint(q)+1)
+}
+
+// align pads with zero bits to a whole byte.
+func (b *flacBits) align() {
+	if b.bits > 0 {
+		b.write(0, 8-b.bits)
+	}
+}
+
+// length returns the number of bits written.
+func (b *flacBits) length() int {
+	return 8*len(b.buf) + int(b.bits)
+}
+
+var flacCRC8Table, flacCRC16Table = flacCRCTables()
+
+// flacCRCTables returns the tables of the frame header CRC-8, polynomial
+// x^8+x^2+x+1, and the frame CRC-16, polynomial x^16+x^15+x^2+1.
+func flacCRCTables() (crc8 [256]uint8, crc16 [256]uint16) {
+	for i := 0; i < 256; i++ {
+		c8 := uint8(i)
+		c16 := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8[i], crc16[i] = c8, c16
+	}
+	return
+}
+
+func flacCRC8(data []byte) uint8 {
+	var crc uint8
+	for _, b := range data {
+		crc = flacCRC8Table[crc^b]
+	}
+	return crc
+}
+
+func flacCRC16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc = crc<<8 ^ flacCRC16Table[byte(crc>>8)^b]
+	}
+	return crc
+}
+
+// FlacWriter encodes 16-bit samples to a FLAC file. The stream information
+// with the sample count and MD5 is filled in by Close, so the file must be
+// seekable.
+type FlacWriter struct {
+	w          io.WriteSeeker
+	sampleRate uint32
+	channels   uint16
+
+	// Samples of each channel waiting for a whole block.
+	pending [][]int32
+
+	frames       uint64
+	samples      uint64
+	minFrameSize uint32
+	maxFrameSize uint32
+	md5          hash.Hash
+
+	frame  flacBits
+	bytes  []byte
+	window []float64
+}
+
+// NewFlacWriter writes the FLAC stream header for the given sample rate and
+// number of channels, up to 8, with Vorbis comments given as "NAME=value".
+func NewFlacWriter(w io.WriteSeeker, sampleRate uint32, channels uint16, comments []string) (*FlacWriter, error) {
+	if channels < 1 || channels > 8 {
+		return nil, errors.New("FLAC has 1 to 8 channels")
+	}
+	if sampleRate == 0 || sampleRate >= 1<<20 {
+		return nil, errors.New("sample rate out of range for FLAC")
+	}
+
+	fw := &FlacWriter{
+		w:          w,
+		sampleRate: sampleRate,
+		channels:   channels,
+		pending:    make([][]int32, channels),
+		md5:        md5.New(),
+	}
+	for c := range fw.pending {
+		fw.pending[c] = make([]int32, 0, FLAC_BLOCKSIZE)
+	}
+
+	var header flacBits
+	header.buf = append(header.buf, "fLaC"...)
+	fw.writeStreamInfo(&header)
+
+	comment := binary.LittleEndian.AppendUint32(nil, uint32(len(FLAC_VENDOR)))
+	comment = append(comment, FLAC_VENDOR...)
+	comment = binary.LittleEndian.AppendUint32(comment, uint32(len(comments)))
+	for _, c := range comments {
+		comment = binary.LittleEndian.AppendUint32(comment, uint32(len(c)))
+		comment = append(comment, c...)
+	}
+	header.write(1, 1)
+	header.write(FLAC_VORBIS_COMMENT, 7)
+	header.write(uint64(len(comment)), 24)
+	header.buf = append(header.buf, comment...)
+
+	if _, err := w.Write(header.buf); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// writeStreamInfo writes the stream information block, which is never the
+// last one.
+func (fw *FlacWriter) writeStreamInfo(b *flacBits) {
+	b.write(0, 1)
+	b.write(FLAC_STREAMINFO, 7)
+	b.write(34, 24)
+	b.write(FLAC_BLOCKSIZE, 16)
+	b.write(FLAC_BLOCKSIZE, 16)
+	b.write(uint64(fw.minFrameSize), 24)
+	b.write(uint64(fw.maxFrameSize), 24)
+	b.write(uint64(fw.sampleRate), 20)
+	b.write(uint64(fw.channels-1), 3)
+	b.write(FLAC_BITS_PER_SAMPLE-1, 5)
+	b.write(fw.samples>>32, 4)
+	b.write(fw.samples, 32)
+
+	var sum [md5.Size]byte
+	if fw.samples > 0 {
+		fw.md5.Sum(sum[:0])
+	}
+	b.buf = append(b.buf, sum[:]...)
+}
+
+// Write writes interleaved samples, a whole number of frames.
+func (fw *FlacWriter) Write(samples []int16) error {
+	channels := int(fw.channels)
+	if len(samples)%channels != 0 {
+		return errors.New("partial FLAC frame")
+	}
+
+	fw.bytes = fw.bytes[:0]
+	for _, v := range samples {
+		fw.bytes = binary.LittleEndian.AppendUint16(fw.bytes, uint16(v))
+	}
+	fw.md5.Write(fw.bytes)
+
+	for i := 0; i < len(samples); i += channels {
+		for c := range fw.pending {
+			fw.pending[c] = append(fw.pending[c], int32(samples[i+c]))
+		}
+		if len(fw.pending[0]) == FLAC_BLOCKSIZE {
+			if err := fw.writeFrame(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close encodes the last, shorter block and fills in the stream
+// information. It does not close the underlying file.
+func (fw *FlacWriter) Close() error {
+	if len(fw.pending[0]) > 0 {
+		if err := fw.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fw.w.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	var info flacBits
+	fw.writeStreamInfo(&info)
+	if _, err := fw.w.Write(info.buf); err != nil {
+		return err
+	}
+	_, err := fw.w.Seek(0, io.SeekEnd)
+	return err
+}
+
+// writeFrame encodes the pending samples as one frame.
+func (fw *FlacWriter) writeFrame() error {
+	n := len(fw.pending[0])
+	b := &fw.frame
+	b.reset()
+
+	// Stereo is coded as left and right, or with their difference on one
+	// channel, whichever is smallest.
+	assignment := uint64(fw.channels - 1)
+	subframes := make([]*flacSubframe, fw.channels)
+	for c, x := range fw.pending {
+		subframes[c] = fw.analyze(x, FLAC_BITS_PER_SAMPLE)
+	}
+	if fw.channels == 2 {
+		left, right := fw.pending[0], fw.pending[1]
+		mid, side := make([]int32, n), make([]int32, n)
+		for i := range mid {
+			mid[i] = (left[i] + right[i]) >> 1
+			side[i] = left[i] - right[i]
+		}
+		l, r := subframes[0], subframes[1]
+		m, s := fw.analyze(mid, FLAC_BITS_PER_SAMPLE), fw.analyze(side, FLAC_BITS_PER_SAMPLE+1)
+		switch min(l.bits+r.bits, l.bits+s.bits, s.bits+r.bits, m.bits+s.bits) {
+		case l.bits + r.bits:
+		case l.bits + s.bits:
+			assignment, subframes = FLAC_LEFT_SIDE, []*flacSubframe{l, s}
+		case s.bits + r.bits:
+			assignment, subframes = FLAC_SIDE_RIGHT, []*flacSubframe{s, r}
+		default:
+			assignment, subframes = FLAC_MID_SIDE, []*flacSubframe{m, s}
+		}
+	}
+
+	// Frame header, fixed block size strategy.
+	b.write(0x3ffe, 14)
+	b.write(0, 1)
+	b.write(0, 1)
+	switch {
+	case n == FLAC_BLOCKSIZE:
+		b.write(0xc, 4)
+	case n <= 256:
+		b.write(0x6, 4)
+	default:
+		b.write(0x7, 4)
+	}
+	rateCode := flacSampleRateCode(fw.sampleRate)
+	b.write(rateCode, 4)
+	b.write(assignment, 4)
+	b.write(0x4, 3)
+	b.write(0, 1)
+	b.buf = appendFlacUTF8(b.buf, fw.frames)
+	switch {
+	case n == FLAC_BLOCKSIZE:
+	case n <= 256:
+		b.write(uint64(n-1), 8)
+	default:
+		b.write(uint64(n-1), 16)
+	}
+	switch rateCode {
+	case 0xc:
+		b.write(uint64(fw.sampleRate/1000), 8)
+	case 0xd:
+		b.write(uint64(fw.sampleRate), 16)
+	case 0xe:
+		b.write(uint64(fw.sampleRate/10), 16)
+	}
+	b.write(uint64(flacCRC8(b.buf)), 8)
+
+	for _, sf := range subframes {
+		sf.write(b)
+	}
+	b.align()
+	b.write(uint64(flacCRC16(b.buf)), 16)
+
+	if _, err := fw.w.Write(b.buf); err != nil {
+		return err
+	}
+
+	size := uint32(len(b.buf))
+	if fw.frames == 0 || size < fw.minFrameSize {
+		fw.minFrameSize = size
+	}
+	fw.maxFrameSize = max(fw.maxFrameSize, size)
+	fw.frames++
+	fw.samples += uint64(n)
+	for c := range fw.pending {
+		fw.pending[c] = fw.pending[c][:0]
+	}
+	return nil
+}
+
+// flacSampleRateCode returns the frame header code of a sample rate, 0 to
+// take it from the stream information.
+func flacSampleRateCode(rate uint32) uint64 {
+	switch rate {
+	case 88200:
+		return 0x1
+	case 176400:
+		return 0x2
+	case 192000:
+		return 0x3
+	case 8000:
+		return 0x4
+	case 16000:
+		return 0x5
+	case 22050:
+		return 0x6
+	case 24000:
+		return 0x7
+	case 32000:
+		return 0x8
+	case 44100:
+		return 0x9
+	case 48000:
+		return 0xa
+	case 96000:
+		return 0xb
+	}
+	switch {
+	case rate%1000 == 0 && rate/1000 < 256:
+		return 0xc
+	case rate < 1<<16:
+		return 0xd
+	case rate%10 == 0 && rate/10 < 1<<16:
+		return 0xe
+	}
+	return 0
+}
+
+// appendFlacUTF8 appends a frame number coded like UTF-8, extended to 36
+// bits.
+func appendFlacUTF8(buf []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(buf, byte(v))
+	}
+	n := 1
+	for v >= 1<<(5*n+6) && n < 6 {
+		n++
+	}
+	buf = append(buf, ^byte(0xff>>(n+1))|byte(v>>(6*n)))
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, 0x80|byte(v>>(6*i))&0x3f)
+	}
+	return buf
+}
+
+// flacSubframe is a channel of a frame and the way to code it, with the
+// residual of the predictor and its Rice partitioning.
+type flacSubframe struct {
+	x    []int32
+	bps  uint
+	kind int
+	bits int
+
+	order     int
+	qlp       []int32
+	shift     int
+	residual  []int32
+	partition int
+	params    []uint
+}
+
+// analyze finds the predictor that codes a channel in the fewest bits,
+// falling back to a constant or the plain samples. Of the linear
+// predictors, only the order with the least estimated size is tried.
+func (fw *FlacWriter) analyze(x []int32, bps uint) *flacSubframe {
+	n := len(x)
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return &flacSubframe{x: x, bps: bps, kind: FLAC_SUBFRAME_CONSTANT, bits: 8 + int(bps)}
+	}
+
+	best := &flacSubframe{x: x, bps: bps, kind: FLAC_SUBFRAME_VERBATIM, bits: 8 + n*int(bps)}
+	spare := make([]int32, n)
+	try := func(sf *flacSubframe) {
+		sf.bits = 8 + sf.order*int(bps) + 6
+		if sf.kind == FLAC_SUBFRAME_LPC {
+			sf.bits += 4 + 5 + sf.order*FLAC_LPC_PRECISION
+		}
+		riceBits, partition, params := flacRiceParameters(sf.residual, sf.order)
+		sf.bits += riceBits
+		sf.partition, sf.params = partition, params
+		if sf.bits < best.bits {
+			best, sf = sf, best
+		}
+		if sf.residual != nil {
+			spare = sf.residual
+		} else {
+			spare = make([]int32, n)
+		}
+	}
+
+	for order := 0; order <= FLAC_MAX_FIXED_ORDER && order < n; order++ {
+		flacFixedResidual(x, order, spare)
+		try(&flacSubframe{x: x, bps: bps, kind: FLAC_SUBFRAME_FIXED, order: order, residual: spare})
+	}
+	if n > 2*FLAC_MAX_LPC_ORDER {
+		if len(fw.window) != n {
+			fw.window = flacTukeyWindow(n)
+		}
+		if coefs := flacBestLPC(x, fw.window, bps, FLAC_MAX_LPC_ORDER); coefs != nil {
+			if qlp, shift, ok := flacQuantize(coefs); ok && flacLPCResidual(x, qlp, shift, spare) {
+				try(&flacSubframe{x: x, bps: bps, kind: FLAC_SUBFRAME_LPC, order: len(qlp), qlp: qlp, shift: shift, residual: spare})
+			}
+		}
+	}
+	return best
+}
+
+// write writes the subframe.
+func (sf *flacSubframe) write(b *flacBits) {
+	switch sf.kind {
+	case FLAC_SUBFRAME_CONSTANT:
+		b.write(FLAC_SUBFRAME_CONSTANT<<1, 8)
+		b.writeSigned(int64(sf.x[0]), sf.bps)
+		return
+	case FLAC_SUBFRAME_VERBATIM:
+		b.write(FLAC_SUBFRAME_VERBATIM<<1, 8)
+		for _, v := range sf.x {
+			b.writeSigned(int64(v), sf.bps)
+		}
+		return
+	case FLAC_SUBFRAME_LPC:
+		b.write(uint64(FLAC_SUBFRAME_LPC|(sf.order-1))<<1, 8)
+	default:
+		b.write(uint64(FLAC_SUBFRAME_FIXED|sf.order)<<1, 8)
+	}
+
+	for _, v := range sf.x[:sf.order] {
+		b.writeSigned(int64(v), sf.bps)
+	}
+	if sf.kind == FLAC_SUBFRAME_LPC {
+		b.write(FLAC_LPC_PRECISION-1, 4)
+		b.writeSigned(int64(sf.shift), 5)
+		for _, q := range sf.qlp {
+			b.writeSigned(int64(q), FLAC_LPC_PRECISION)
+		}
+	}
+
+	// Rice coding with 4-bit parameters.
+	b.write(0, 2)
+	b.write(uint64(sf.partition), 4)
+	size := len(sf.x) >> sf.partition
+	for p, k := range sf.params {
+		b.write(uint64(k), 4)
+		start := max(p*size, sf.order)
+		for _, r := range sf.residual[start : (p+1)*size] {
+			u := flacZigzag(r)
+			b.writeUnary(u >> k)
+			b.write(u, k)
+		}
+	}
+}
+
+// flacZigzag maps signed residuals to unsigned, 0, -1, 1, -2... to 0, 1, 2,
+// 3...
+func flacZigzag(r int32) uint64 {
+	return uint64(uint32(r<<1) ^ uint32(r>>31))
+}
+
+// flacFixedResidual computes the residual of a fixed polynomial predictor
+// past the warm up samples.
+func flacFixedResidual(x []int32, order int, residual []int32) {
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 0:
+			residual[i] = x[i]
+		case 1:
+			residual[i] = x[i] - x[i-1]
+		case 2:
+			residual[i] = x[i] - 2*x[i-1] + x[i-2]
+		case 3:
+			residual[i] = x[i] - 3*x[i-1] + 3*x[i-2] - x[i-3]
+		case 4:
+			residual[i] = x[i] - 4*x[i-1] + 6*x[i-2] - 4*x[i-3] + x[i-4]
+		}
+	}
+}
+
+// flacTukeyWindow returns a Tukey window of n samples, tapering over a
+// quarter at either end.
+func flacTukeyWindow(n int) []float64 {
+	window := make([]float64, n)
+	edge := 0.25 * float64(n-1)
+	for i := range window {
+		window[i] = 1
+		if d := min(float64(i), float64(n-1-i)); d < edge {
+			window[i] = 0.5 * (1 - math.Cos(math.Pi*d/edge))
+		}
+	}
+	return window
+}
+
+// flacBestLPC returns the linear predictor of up to maxOrder coefficients
+// with the least estimated size of the subframe, from the Levinson-Durbin
+// recursion on the autocorrelation of the windowed channel. Coefficient j
+// applies to the sample j+1 back.
+func flacBestLPC(x []int32, window []float64, bps uint, maxOrder int) []float64 {
+	n := len(x)
+	windowed := make([]float64, n)
+	for i, v := range x {
+		windowed[i] = float64(v) * window[i]
+	}
+
+	autoc := make([]float64, maxOrder+1)
+	for lag := range autoc {
+		for i := lag; i < n; i++ {
+			autoc[lag] += windowed[i] * windowed[i-lag]
+		}
+	}
+	if autoc[0] == 0 {
+		return nil
+	}
+
+	// A residual of variance v takes about log2(v)/2 bits a sample.
+	var best []float64
+	bestBits := math.Inf(1)
+	a := make([]float64, 0, maxOrder)
+	err := autoc[0]
+	for i := 1; i <= maxOrder; i++ {
+		k := autoc[i]
+		for j, c := range a {
+			k -= c * autoc[i-1-j]
+		}
+		k /= err
+
+		next := make([]float64, i)
+		for j, c := range a {
+			next[j] = c - k*a[i-2-j]
+		}
+		next[i-1] = k
+		a = next
+
+		err *= 1 - k*k
+		if err <= 0 {
+			return a
+		}
+		bits := float64(n-i)*max(0.5*math.Log2(err/float64(n)), 0) + float64(i*(FLAC_LPC_PRECISION+int(bps)))
+		if bits < bestBits {
+			best, bestBits = a, bits
+		}
+	}
+	return best
+}
+
+// flacQuantize rounds predictor coefficients to FLAC_LPC_PRECISION bits
+// with a shift, carrying the rounding error over to the next coefficient.
+func flacQuantize(coefs []float64) ([]int32, int, bool) {
+	cmax := 0.0
+	for _, c := range coefs {
+		cmax = max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return nil, 0, false
+	}
+
+	_, exp := math.Frexp(cmax)
+	shift := min(FLAC_LPC_PRECISION-1-exp, 15)
+	if shift < 0 {
+		return nil, 0, false
+	}
+
+	qmax := int32(1)<<(FLAC_LPC_PRECISION-1) - 1
+	qlp := make([]int32, len(coefs))
+	carry := 0.0
+	for i, c := range coefs {
+		carry += c * float64(int(1)<<shift)
+		q := int32(math.Round(carry))
+		q = min(max(q, -qmax-1), qmax)
+		carry -= float64(q)
+		qlp[i] = q
+	}
+	return qlp, shift, true
+}
+
+// flacLPCResidual computes the residual of a quantized linear predictor
+// past the warm up samples. It fails if the residual gets out of range.
+func flacLPCResidual(x []int32, qlp []int32, shift int, residual []int32) bool {
+	for i := len(qlp); i < len(x); i++ {
+		var sum int64
+		for j, q := range qlp {
+			sum += int64(q) * int64(x[i-1-j])
+		}
+		r := int64(x[i]) - sum>>shift
+		if r < math.MinInt32/2 || r > math.MaxInt32/2 {
+			return false
+		}
+		residual[i] = int32(r)
+	}
+	return true
+}
+
+// flacRiceParameters chooses the partitioning and the Rice parameter of
+// each partition of a residual, and returns the estimated size in bits.
+func flacRiceParameters(residual []int32, order int) (int, int, []uint) {
+	n := len(residual)
+
+	// The finest partitioning with partitions of equal size, each longer
+	// than the warm up.
+	maxPartition := 0
+	for maxPartition < FLAC_MAX_PARTITION_ORDER && n%(2<<maxPartition) == 0 && n>>(maxPartition+1) > order {
+		maxPartition++
+	}
+
+	sums := make([]uint64, 1<<maxPartition)
+	size := n >> maxPartition
+	for i := order; i < n; i++ {
+		sums[i/size] += flacZigzag(residual[i])
+	}
+
+	bestBits, bestPartition := -1, 0
+	var bestParams []uint
+	for partition := maxPartition; partition >= 0; partition-- {
+		size := n >> partition
+		params := make([]uint, len(sums))
+		total := 0
+		for p, sum := range sums {
+			count := size
+			if p == 0 {
+				count -= order
+			}
+			k, b := flacRiceParameter(sum, count)
+			params[p] = k
+			total += b
+		}
+		if bestBits < 0 || total < bestBits {
+			bestBits, bestPartition, bestParams = total, partition, params
+		}
+
+		// Merge pairs of partitions for the next coarser partitioning.
+		if partition > 0 {
+			for p := range sums[:len(sums)/2] {
+				sums[p] = sums[2*p] + sums[2*p+1]
+			}
+			sums = sums[:len(sums)/2]
+		}
+	}
+	return bestBits, bestPartition, bestParams
+}
+
+// flacRiceParameter returns the Rice parameter for a partition of count
+// residuals adding up to sum once mapped to unsigned, and the estimated size
+// of the partition in bits.
+func flacRiceParameter(sum uint64, count int) (uint, int) {
+	// The best parameter is close to log2 of the mean.
+	mean := sum / uint64(max(count, 1))
+	k0 := uint(min(max(bits.Len64(mean), 1), FLAC_MAX_RICE_PARAM))
+	bestK, bestBits := uint(0), -1
+	for k := k0 - 1; k <= min(k0+1, FLAC_MAX_RICE_PARAM); k++ {
+		b := 4 + count*int(k+1) + int(sum>>k)
+		if bestBits < 0 || b < bestBits {
+			bestK, bestBits = k, b
+		}
+	}
+	return bestK, bestBits
+}
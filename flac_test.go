@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlacUTF8(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0xc2, 0x80}},
+		{0x7ff, []byte{0xdf, 0xbf}},
+		{0x800, []byte{0xe0, 0xa0, 0x80}},
+		{0xffff, []byte{0xef, 0xbf, 0xbf}},
+		{0x10000, []byte{0xf0, 0x90, 0x80, 0x80}},
+		{0x1fffff, []byte{0xf7, 0xbf, 0xbf, 0xbf}},
+		{0x200000, []byte{0xf8, 0x88, 0x80, 0x80, 0x80}},
+		{0x3ffffff, []byte{0xfb, 0xbf, 0xbf, 0xbf, 0xbf}},
+		{0x4000000, []byte{0xfc, 0x84, 0x80, 0x80, 0x80, 0x80}},
+		{0x7fffffff, []byte{0xfd, 0xbf, 0xbf, 0xbf, 0xbf, 0xbf}},
+		{0x80000000, []byte{0xfe, 0x82, 0x80, 0x80, 0x80, 0x80, 0x80}},
+		{0xfffffffff, []byte{0xfe, 0xbf, 0xbf, 0xbf, 0xbf, 0xbf, 0xbf}},
+	}
+	for _, test := range tests {
+		if got := appendFlacUTF8([]byte{0x55}, test.v); !bytes.Equal(got[1:], test.want) || got[0] != 0x55 {
+			t.Errorf("%#x: % x, want 55 % x", test.v, got, test.want)
+		}
+	}
+}
+
+// The check values of CRC-8 with polynomial 0x07 and CRC-16 with polynomial
+// 0x8005, both unreflected and starting from zero.
+func TestFlacCRC(t *testing.T) {
+	check := []byte("123456789")
+	if got := flacCRC8(check); got != 0xf4 {
+		t.Errorf("CRC-8 = %#02x, want 0xf4", got)
+	}
+	if got := flacCRC16(check); got != 0xfee8 {
+		t.Errorf("CRC-16 = %#04x, want 0xfee8", got)
+	}
+	if flacCRC8(nil) != 0 || flacCRC16(nil) != 0 {
+		t.Error("CRC of nothing not zero")
+	}
+}
+
+func TestFlacRiceParameters(t *testing.T) {
+	// Residuals alternating between silence and loud in runs of the given
+	// length, so that the finest partitioning allowed is the best.
+	alternating := func(n int, run int) []int32 {
+		r := make([]int32, n)
+		for i := range r {
+			if i/run%2 == 1 {
+				r[i] = int32(1000 - i%7)
+			}
+		}
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		residual  []int32
+		order     int
+		partition int
+	}{
+		{"the maximum partition order", alternating(4096, 16), 0, FLAC_MAX_PARTITION_ORDER},
+		{"partitions of 32", alternating(4096, 32), 0, 7},
+		{"odd block", alternating(1001, 1), 0, 0},
+		{"block of 3 * 2^3", alternating(24, 3), 0, 3},
+		{"partitions longer than the warm up", alternating(24, 6), 4, 2},
+		{"no partition longer than the warm up", alternating(24, 3), 12, 0},
+		{"the same level throughout", make([]int32, 4096), 0, 0},
+	}
+	for _, test := range tests {
+		bits, partition, params := flacRiceParameters(test.residual, test.order)
+		if partition != test.partition {
+			t.Errorf("%s: partition order %d, want %d", test.name, partition, test.partition)
+		}
+		if len(params) != 1<<partition {
+			t.Errorf("%s: %d parameters for partition order %d", test.name, len(params), partition)
+		}
+
+		// The estimate is at least the size of the coded partitions, and
+		// at most a bit a residual more.
+		size := len(test.residual) >> partition
+		coded := 0
+		for p, k := range params {
+			coded += 4
+			for _, r := range test.residual[max(p*size, test.order) : (p+1)*size] {
+				coded += int(flacZigzag(r)>>k) + 1 + int(k)
+			}
+		}
+		if bits < coded || bits > coded+len(test.residual) {
+			t.Errorf("%s: %d bits estimated, %d coded", test.name, bits, coded)
+		}
+	}
+
+	// The warm up samples are not coded.
+	residual := alternating(4096, 16)
+	bits, _, _ := flacRiceParameters(residual, 8)
+	for i := range residual[:8] {
+		residual[i] = math.MaxInt16
+	}
+	if b, _, _ := flacRiceParameters(residual, 8); b != bits {
+		t.Errorf("%d bits with loud warm up samples, want %d", b, bits)
+	}
+}
+
+// flacTestBlock returns a stereo block that codes best with the channel
+// assignment, from a smooth signal, a square wave and noise. Mono tests take
+// the left channel.
+func flacTestBlock(assignment int, n int, offset int) (left, right []int32) {
+	left, right = make([]int32, n), make([]int32, n)
+	seed := uint32(offset*7919 + assignment)
+	for i := range left {
+		seed = seed*1664525 + 1013904223
+		noise := int32(seed>>20) - 2048
+		smooth := int32(8000 * math.Sin(float64(offset+i)*0.01))
+		square := int32(4000)
+		if (offset+i)/32%2 == 1 {
+			square = -4000
+		}
+
+		switch assignment {
+		case 0, 1:
+			left[i], right[i] = noise, smooth
+		case FLAC_LEFT_SIDE:
+			left[i], right[i] = smooth, smooth-square
+		case FLAC_SIDE_RIGHT:
+			left[i], right[i] = smooth+square, smooth
+		case FLAC_MID_SIDE:
+			left[i], right[i] = smooth+square/2, smooth-square/2
+		}
+	}
+	return left, right
+}
+
+func TestFlacRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		channels uint16
+		blocks   []int // channel assignment of each block
+		last     int   // length of the last block
+	}{
+		{"mono", 1, []int{0, 0}, 1000},
+		{"mono, one short block", 1, []int{0}, 100},
+		{"stereo", 2, []int{1, FLAC_LEFT_SIDE, FLAC_SIDE_RIGHT, FLAC_MID_SIDE, FLAC_MID_SIDE}, 3000},
+		{"stereo, one short block", 2, []int{FLAC_LEFT_SIDE}, 200},
+	}
+
+	for _, test := range tests {
+		var samples []int16
+		for block, assignment := range test.blocks {
+			n := FLAC_BLOCKSIZE
+			if block == len(test.blocks)-1 {
+				n = test.last
+			}
+			left, right := flacTestBlock(assignment, n, block*FLAC_BLOCKSIZE)
+			for i := range left {
+				samples = append(samples, int16(left[i]))
+				if test.channels == 2 {
+					samples = append(samples, int16(right[i]))
+				}
+			}
+		}
+
+		file, err := os.Create(filepath.Join(t.TempDir(), "test.flac"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewFlacWriter(file, 44100, test.channels, []string{"TITLE=test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Odd sized writes, not lined up with the blocks.
+		for i := 0; i < len(samples); {
+			n := min(777*int(test.channels), len(samples)-i)
+			if err := w.Write(samples[i : i+n]); err != nil {
+				t.Fatal(err)
+			}
+			i += n
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		data, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, assignments, err := decodeFlac(data)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !slicesEqual(got, samples) {
+			t.Errorf("%s: decoded samples differ", test.name)
+		}
+		if test.channels == 2 && !slicesEqual(assignments, test.blocks) {
+			t.Errorf("%s: channel assignments %v, want %v", test.name, assignments, test.blocks)
+		}
+	}
+}
+
+func slicesEqual[T comparable](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// flacReader reads a bit stream, most significant bit first.
+type flacReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *flacReader) read(n int) uint64 {
+	var v uint64
+	for i := 0; i < n; i++ {
+		v = v<<1 | uint64(r.data[r.pos>>3]>>(7-r.pos&7)&1)
+		r.pos++
+	}
+	return v
+}
+
+func (r *flacReader) readSigned(n int) int64 {
+	v := int64(r.read(n))
+	if n > 0 && v>>(n-1) != 0 {
+		v -= 1 << n
+	}
+	return v
+}
+
+func (r *flacReader) readUnary() uint64 {
+	var q uint64
+	for r.read(1) == 0 {
+		q++
+	}
+	return q
+}
+
+// flacError is a decoding error of the test decoder.
+type flacError string
+
+func (e flacError) Error() string { return string(e) }
+
+// decodeFlac decodes the subset of FLAC that the writer codes, checking the
+// CRCs, the frame numbers and the stream information, and returns the
+// interleaved samples and the channel assignment of each frame.
+func decodeFlac(data []byte) (samples []int16, assignments []int, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = flacError("truncated stream")
+		}
+	}()
+
+	if string(data[:4]) != "fLaC" {
+		return nil, nil, flacError("no FLAC stream")
+	}
+	r := &flacReader{data: data, pos: 32}
+	var channels, bps int
+	var total, minFrame, maxFrame uint64
+	var sum []byte
+	for last := uint64(0); last == 0; {
+		last = r.read(1)
+		kind := r.read(7)
+		length := int(r.read(24))
+		start := r.pos / 8
+		if kind == FLAC_STREAMINFO {
+			if r.read(16) != FLAC_BLOCKSIZE || r.read(16) != FLAC_BLOCKSIZE {
+				return nil, nil, flacError("block size")
+			}
+			minFrame, maxFrame = r.read(24), r.read(24)
+			if r.read(20) != 44100 {
+				return nil, nil, flacError("sample rate")
+			}
+			channels, bps = int(r.read(3))+1, int(r.read(5))+1
+			total = r.read(36)
+			sum = data[r.pos/8 : r.pos/8+16]
+		}
+		r.pos = (start + length) * 8
+	}
+
+	var frames uint64
+	for r.pos/8 < len(data) {
+		start := r.pos / 8
+		if r.read(14) != 0x3ffe || r.read(2) != 0 {
+			return nil, nil, flacError("frame sync")
+		}
+		blockCode, rateCode, assignment := r.read(4), r.read(4), int(r.read(4))
+		if r.read(3) != 0x4 || r.read(1) != 0 || rateCode != 0x9 {
+			return nil, nil, flacError("frame header")
+		}
+		if !bytes.HasPrefix(data[r.pos/8:], appendFlacUTF8(nil, frames)) {
+			return nil, nil, flacError("frame number")
+		}
+		r.pos += 8 * len(appendFlacUTF8(nil, frames))
+		n := FLAC_BLOCKSIZE
+		switch blockCode {
+		case 0xc:
+		case 0x6:
+			n = int(r.read(8)) + 1
+		case 0x7:
+			n = int(r.read(16)) + 1
+		default:
+			return nil, nil, flacError("block size code")
+		}
+		if uint8(r.read(8)) != flacCRC8(data[start:r.pos/8-1]) {
+			return nil, nil, flacError("frame header CRC")
+		}
+
+		sub := make([][]int64, channels)
+		for c := range sub {
+			sbps := bps
+			if (assignment == FLAC_LEFT_SIDE || assignment == FLAC_MID_SIDE) && c == 1 || assignment == FLAC_SIDE_RIGHT && c == 0 {
+				sbps++
+			}
+			if sub[c], err = decodeFlacSubframe(r, n, sbps); err != nil {
+				return nil, nil, err
+			}
+		}
+		switch assignment {
+		case FLAC_LEFT_SIDE:
+			for i := range sub[1] {
+				sub[1][i] = sub[0][i] - sub[1][i]
+			}
+		case FLAC_SIDE_RIGHT:
+			for i := range sub[0] {
+				sub[0][i] += sub[1][i]
+			}
+		case FLAC_MID_SIDE:
+			for i := range sub[0] {
+				mid, side := sub[0][i]<<1|sub[1][i]&1, sub[1][i]
+				sub[0][i], sub[1][i] = (mid+side)>>1, (mid-side)>>1
+			}
+		default:
+			if assignment != channels-1 {
+				return nil, nil, flacError("channel assignment")
+			}
+		}
+
+		if r.pos&7 != 0 && r.read(8-r.pos&7) != 0 {
+			return nil, nil, flacError("padding")
+		}
+		if uint16(r.read(16)) != flacCRC16(data[start:r.pos/8-2]) {
+			return nil, nil, flacError("frame CRC")
+		}
+		if size := uint64(r.pos/8 - start); size < minFrame || size > maxFrame {
+			return nil, nil, flacError("frame size")
+		}
+
+		for i := 0; i < n; i++ {
+			for c := range sub {
+				samples = append(samples, int16(sub[c][i]))
+			}
+		}
+		if channels == 2 {
+			assignments = append(assignments, assignment)
+		}
+		frames++
+	}
+
+	if uint64(len(samples)/channels) != total {
+		return nil, nil, flacError("sample count")
+	}
+	var b []byte
+	for _, v := range samples {
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	if got := md5.Sum(b); !bytes.Equal(got[:], sum) {
+		return nil, nil, flacError("MD5")
+	}
+	return samples, assignments, nil
+}
+
+func decodeFlacSubframe(r *flacReader, n int, bps int) ([]int64, error) {
+	if r.read(1) != 0 {
+		return nil, flacError("subframe padding")
+	}
+	kind := int(r.read(6))
+	if r.read(1) != 0 {
+		return nil, flacError("wasted bits")
+	}
+
+	x := make([]int64, n)
+	switch {
+	case kind == FLAC_SUBFRAME_CONSTANT:
+		v := r.readSigned(bps)
+		for i := range x {
+			x[i] = v
+		}
+		return x, nil
+	case kind == FLAC_SUBFRAME_VERBATIM:
+		for i := range x {
+			x[i] = r.readSigned(bps)
+		}
+		return x, nil
+	case kind >= FLAC_SUBFRAME_FIXED && kind <= FLAC_SUBFRAME_FIXED+FLAC_MAX_FIXED_ORDER, kind >= FLAC_SUBFRAME_LPC:
+	default:
+		return nil, flacError("subframe type")
+	}
+
+	lpc := kind >= FLAC_SUBFRAME_LPC
+	order := kind - FLAC_SUBFRAME_FIXED
+	if lpc {
+		order = kind - FLAC_SUBFRAME_LPC + 1
+	}
+	for i := range x[:order] {
+		x[i] = r.readSigned(bps)
+	}
+	var qlp []int64
+	var shift int64
+	if lpc {
+		precision := int(r.read(4)) + 1
+		shift = r.readSigned(5)
+		if shift < 0 {
+			return nil, flacError("negative shift")
+		}
+		for i := 0; i < order; i++ {
+			qlp = append(qlp, r.readSigned(precision))
+		}
+	}
+
+	if r.read(2) != 0 {
+		return nil, flacError("residual coding method")
+	}
+	partition := r.read(4)
+	size := n >> partition
+	residual := make([]int64, n)
+	i := order
+	for p := 0; p < 1<<partition; p++ {
+		k := int(r.read(4))
+		if k == 0xf {
+			return nil, flacError("escaped partition")
+		}
+		for end := (p + 1) * size; i < end; i++ {
+			u := r.readUnary()<<k | r.read(k)
+			residual[i] = int64(u>>1) ^ -int64(u&1)
+		}
+	}
+
+	fixed := [][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+	for i := order; i < n; i++ {
+		var prediction int64
+		if lpc {
+			for j, q := range qlp {
+				prediction += q * x[i-1-j]
+			}
+			prediction >>= shift
+		} else {
+			for j, c := range fixed[order] {
+				prediction += c * x[i-1-j]
+			}
+		}
+		x[i] = residual[i] + prediction
+	}
+	return x, nil
+}
@@ -3,75 +3,45 @@ package main
 // might be useful to look at binary dumps in the terminal:
 // od -h sidtune.dmp | less
 
-// typedef unsigned char Uint8;
-// void OnAudioCallback(void *userdata, Uint8 *stream, int len);
-import "C"
-
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"reflect"
-	"unsafe"
+	"time"
 	resid "yaspg/app/sid"
-
-	"github.com/veandco/go-sdl2/sdl"
-)
-
-var (
-	opt               *SidPlayerSettings
-	player            *SidPlayer
-	cpuplay_cnt_limit int = 882
-	cpuplay_cnt       int
-	dev               sdl.AudioDeviceID
 )
 
 const MAX_INSTR uint16 = 0xFFFF
 
-//export OnAudioCallback
-func OnAudioCallback(userdata unsafe.Pointer, stream *C.Uint8, length C.int) {
-
-	n := int(length)
-	hdr := reflect.SliceHeader{Data: uintptr(unsafe.Pointer(stream)), Len: n, Cap: n}
-	buf := *(*[]C.Uint8)(unsafe.Pointer(&hdr))
-
-	// Main calculation loop
-	for i := 0; i < n; i += 4 {
-		// Execute 6510 play routine if due
-		cpuplay_cnt++
-		if cpuplay_cnt >= cpuplay_cnt_limit {
-			cpuplay_cnt = 0
-			player.Tick()
-			if player.framePeriod == 0 {
-				player.framePeriod = 20000
-			}
-			cpuplay_cnt_limit = int(player.sampleFreq) / (int(player.clockFreq) / int(player.framePeriod))
-		}
-
-		// Calculate sid output
-		player.sid.Clock(resid.CycleCount(player.delta_t))
-		sample := (player.sid.Output())
-
-		// Write to audio output buffer
-		sampleHi := (sample >> 8)
-		sampleLo := (sample & 0xFF)
-		buf[i] = C.Uint8(sampleLo)
-		buf[i+1] = C.Uint8(sampleHi)
-		buf[i+2] = C.Uint8(sampleLo)
-		buf[i+3] = C.Uint8(sampleHi)
-	}
-}
+// messages is where the output for the user goes: stdout, or stderr when the
+// audio is written to stdout.
+var messages io.Writer = os.Stdout
 
 func main() {
-	opt = NewSidPlayerSettings()
-	player = NewSidPlayer()
-
-	// Parse arguments
-	opt.ParseArgs()
+	opt := NewSidPlayerSettings()
+	player := NewSidPlayer()
+
+	// Parse arguments, after the command if any
+	args := os.Args[1:]
+	var render *RenderSettings
+	if len(args) > 0 && args[0] == "render" {
+		render = NewRenderSettings()
+		render.AddFlags()
+		args = args[1:]
+	}
+	if err := opt.ParseArgs(args); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if opt.Output == "raw" {
+		messages = os.Stderr
+	}
 
 	if len(flag.Args()) == 0 {
-		fmt.Println("Usage: go run main.go [options] <sidfile>")
+		fmt.Fprintln(messages, "Usage: go run main.go [options] <sidfile>")
+		fmt.Fprintln(messages, "       go run main.go render -o <file> [options] <sidfile>")
 		os.Exit(1)
 	}
 
@@ -83,39 +53,144 @@ func main() {
 	// get file name of sid tune
 	sidName := flag.Arg(0)
 
-	player.setSampleRate(uint32(opt.Samplefreq))
-	player.setSIDModel(resid.Model(opt.SidModel))
-	player.Load(sidName)
-
-	if err := sdl.Init(sdl.INIT_AUDIO); err != nil {
+	err := setupPlayer(player, opt, sidName)
+	defer func() {
+		if err := player.closeStems(); err != nil {
+			log.Println(err)
+		}
+	}()
+	if err != nil {
 		log.Println(err)
 		return
 	}
-	defer sdl.Quit()
 
-	spec := &sdl.AudioSpec{}
-	spec.Callback = sdl.AudioCallback(C.OnAudioCallback)
-	spec.Samples = 4096
-	spec.Channels = 2
-	spec.Freq = int32(opt.Samplefreq)
-	spec.Format = sdl.AUDIO_S16SYS
+	if render != nil {
+		// No rewinding, and the subtune given to the command.
+		player.setRewind(0, 0)
+		if render.Subtune > -1 {
+			opt.Subtune = render.Subtune
+		}
+		if err := startPlayer(player, opt); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := renderTune(player, render, sidName); err != nil {
+			log.Println(err)
+		}
+		player.Stop()
+		return
+	}
 
-	var err error
-	if dev, err = sdl.OpenAudioDevice("", false, spec, nil, 0); err != nil {
+	sink, err := newAudioSink(opt.Output, opt.Buffer)
+	if err != nil {
 		log.Println(err)
 		return
 	}
-
-	if opt.Subtune > -1 {
-		player.currentSong = uint16(opt.Subtune)
+	deviceSamples, err := sink.Open(uint32(opt.Samplefreq))
+	if err != nil {
+		log.Println(err)
+		return
 	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
-	player.Init()
-	player.Start()
+	if err := startPlayer(player, opt); err != nil {
+		log.Println(err)
+		return
+	}
 
-	sdl.PauseAudioDevice(dev, false)
-	fmt.Println("Press the Enter Key to stop anytime")
-	fmt.Scanln()
-	sdl.CloseAudioDevice(dev)
+	stream := newAudioStream(player, uint32(opt.Samplefreq), time.Duration(opt.Latency)*time.Millisecond, deviceSamples)
+	stream.start()
+	sink.Start(stream)
+	commandLoop(player)
+	if err := sink.Close(); err != nil {
+		log.Println(err)
+	}
+	stream.close()
 	player.Stop()
 }
+
+// setupPlayer configures the player from the settings and loads the tune.
+func setupPlayer(player *SidPlayer, opt *SidPlayerSettings, sidName string) error {
+	player.setSampleRate(uint32(opt.Samplefreq))
+	player.setSamplingMethod(resid.SamplingMethod(opt.SamplingMethod))
+	player.setDACModel(opt.DACModel)
+	player.setFilterType(resid.FilterType(opt.FilterType))
+	player.setFilterCurve(opt.FilterCurve)
+	player.setWaveTables(opt.WaveTables)
+	player.setDigiBoost(opt.DigiBoost)
+	player.setExternalFilter(opt.OutputFilter, opt.OutputHighPass, opt.OutputLowPass)
+	switch opt.Clip {
+	case "hard":
+		player.setOutputClip(resid.CLIP_HARD)
+	case "soft":
+		player.setOutputClip(resid.CLIP_SOFT)
+	default:
+		return fmt.Errorf("unknown clipping %q, expected hard or soft", opt.Clip)
+	}
+	if opt.PaddleScript != "" {
+		script, err := loadPaddleScript(opt.PaddleScript)
+		if err != nil {
+			return err
+		}
+		player.setPaddleScript(script)
+	}
+	if opt.InputFile != "" {
+		input, err := NewExternalInput(opt.InputFile, opt.InputGain)
+		if err != nil {
+			return err
+		}
+		player.setExternalInput(input)
+	}
+	if opt.Stems != "" {
+		if opt.StemTap != "mix" && opt.StemTap != "voice" {
+			return fmt.Errorf("unknown stem tap %q, expected mix or voice", opt.StemTap)
+		}
+		stems, err := NewStemRecorder(opt.Stems, uint32(opt.Samplefreq), opt.StemFiles, opt.StemTap == "voice")
+		if err != nil {
+			return err
+		}
+		player.setStemRecorder(stems)
+	}
+	player.setRewind(opt.Rewind, time.Duration(opt.RewindInterval*float64(time.Second)))
+	player.setPreferredSIDModel(resid.Model(opt.PreferredSidModel))
+	player.Load(sidName)
+	if opt.SidModel > -1 {
+		player.setSIDModel(resid.Model(opt.SidModel))
+	}
+	if opt.VideoStandard > -1 {
+		player.setVideoStandard(VideoStandard(opt.VideoStandard))
+	}
+	return nil
+}
+
+// startPlayer starts the subtune from the settings, continuing from a
+// snapshot or at a time if given.
+func startPlayer(player *SidPlayer, opt *SidPlayerSettings) error {
+	player.Init()
+	if opt.Subtune > -1 {
+		if err := player.PlayTune(opt.Subtune); err != nil {
+			return err
+		}
+	} else {
+		player.Start()
+	}
+	if opt.Snapshot != "" {
+		if err := player.loadSnapshot(opt.Snapshot); err != nil {
+			return err
+		}
+	}
+	if opt.Seek != "" {
+		t, err := parseTime(opt.Seek)
+		if err == nil {
+			err = player.Seek(t)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
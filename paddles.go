@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paddleEvent sets the paddle positions at a point in time of playback.
+type paddleEvent struct {
+	time time.Duration
+	x, y uint8
+}
+
+// loadPaddleScript reads a paddle script. Each line has a time, as seconds
+// or minutes:seconds, followed by the POTX and POTY values. Lines starting
+// with # are comments.
+//
+//	# time  x    y
+//	0       128  128
+//	1.5     255  128
+//	0:03    0    64
+func loadPaddleScript(fileName string) ([]paddleEvent, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var script []paddleEvent
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected time, x and y", fileName, line)
+		}
+		t, err := parseTime(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+		x, err := strconv.ParseUint(fields[1], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+		y, err := strconv.ParseUint(fields[2], 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+		}
+		script = append(script, paddleEvent{t, uint8(x), uint8(y)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(script, func(i, j int) bool { return script[i].time < script[j].time })
+	return script, nil
+}
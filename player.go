@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
+	"time"
 	psid "yaspg/app/psid"
 	resid "yaspg/app/sid"
 
@@ -11,14 +15,19 @@ import (
 
 const PAL_FRAMERATE float64 = 50.0
 const NTSC_FRAMERATE float64 = 60.0
-const CLOCKFREQ uint32 = 985248
 const SAMPLEFREQ uint32 = 22050
 
+// EXT IN level for digi boost, full scale.
+const DIGIBOOST_LEVEL = -32768
+
 type SidPlayer struct {
 	sid           *resid.Sid
 	mem           *FlatMemoryWithNotification
 	cpu           *cpu.CPU
 	model         resid.Model
+	modelDefault  resid.Model
+	modelSource   string
+	video         VideoStandard
 	songHeader    *psid.PSIDHeader
 	currentSong   uint16
 	isPlaying     bool
@@ -26,20 +35,78 @@ type SidPlayer struct {
 	isInitialized bool
 	framePeriod   uint32
 	frameRate     float64
-	delta_t       uint32
+	frameCycles   resid.CycleCount
 	clockFreq     uint32
 	sampleFreq    uint32
+	sampling      resid.SamplingMethod
+	dacModel      string
+	filterType    resid.FilterType
+	filterCurve   string
+	waveTables    string
+	digiBoost     bool
+	extInput      *ExternalInput
+
+	// Output stage: a board preset, corner frequencies overriding it when
+	// not negative, and the clipping to 16 bits.
+	extFilter   string
+	extFilterHP float64
+	extFilterLP float64
+	outputClip  resid.OutputClip
+
+	// Recording of the mix and the voice taps, nil if none.
+	stems *StemRecorder
+
+	// Memory image and play address of the tune as loaded, to start each
+	// subtune from.
+	image       [64 * 1024]byte
+	playAddress uint16
+
+	// Snapshots to rewind to, nil if rewinding is off.
+	rewind *rewindBuffer
+
+	// SID register writes by the play routine, applied at their cycle
+	// offset into the frame.
+	sidWrites   []sidWrite
+	queueWrites bool
+	tickCycles  uint64
+	frameClock  resid.CycleCount
+
+	// Guards the player state shared with the audio callback.
+	mu sync.Mutex
+
+	// Cycles clocked since the start of the subtune.
+	elapsedCycles uint64
+
+	// Paddle positions, the mouse position when the paddles are driven by
+	// a 1351 mouse, and the scripted paddle positions with the next one to
+	// apply.
+	potx, poty     uint8
+	mouseX, mouseY int
+	paddleScript   []paddleEvent
+	paddleNext     int
+}
+
+type sidWrite struct {
+	cycle resid.CycleCount
+	reg   uint8
+	value uint8
 }
 
 func NewSidPlayer() *SidPlayer {
 	player := &SidPlayer{}
-	player.frameRate = PAL_FRAMERATE
-	player.clockFreq = CLOCKFREQ
 	player.model = resid.MOS6581
+	player.modelDefault = resid.MOS6581
+	player.modelSource = "default"
 	player.sampleFreq = SAMPLEFREQ
-	player.framePeriod = player.clockFreq / uint32(player.frameRate)
+	player.sampling = resid.SAMPLE_FAST
+	player.dacModel = "ideal"
+	player.extFilter = "c64"
+	player.extFilterHP = -1
+	player.extFilterLP = -1
+	player.setVideoStandard(PAL)
 	player.mem = NewFlatMemoryWithNotification()
 	player.mem.AttachWriteNotifier(player)
+	player.mem.AttachReadNotifier(player)
 	player.cpu = cpu.NewCPU(cpu.NMOS, player.mem)
 	player.sid = resid.NewSID()
 	return player
@@ -58,14 +125,24 @@ func (s *SidPlayer) Init() {
 	s.Reset()
 	// audio init
 	// set samplerate from obtained
-	s.sid.SetSamplingParameters(float64(CLOCKFREQ), resid.SAMPLE_FAST, float64(SAMPLEFREQ))
+	s.applySamplingParameters()
 	s.sid.SetModel(s.model)
 
+	timing := s.video.Timing()
+	fmt.Fprintf(messages, "Video standard = %s (%d Hz, %d cycles/frame)\n", timing.Name, timing.ClockFreq, timing.FramePeriod())
+
 	if s.model == resid.MOS6581 {
-		fmt.Println("Sid model = 6581")
+		fmt.Fprintf(messages, "Sid model = 6581 (%s)\n", s.modelSource)
 	} else {
-		fmt.Println("Sid model = 8580")
+		fmt.Fprintf(messages, "Sid model = 8580 (%s)\n", s.modelSource)
 	}
+	s.applyDACModel()
+	s.applyExternalInput()
+	s.applyWaveTables()
+	s.sid.SetFilterType(s.filterType)
+	s.applyFilterCurve()
+	s.applyExternalFilter()
+	s.sid.EnableTaps(s.stems != nil)
 	s.isInitialized = true
 }
 
@@ -82,13 +159,21 @@ func (s *SidPlayer) Load(fileName string) bool {
 
 	err = s.songHeader.LoadHeader(file)
 	check(err)
-	s.songHeader.PrintHeader()
+	s.songHeader.PrintHeader(messages)
 
 	// Load PSID data into cpu memory
 	err = s.songHeader.LoadData(s.cpu, file)
 	check(err)
+	s.image = s.mem.b
+	if s.songHeader.Songs == 0 {
+		// Not a valid PSID value, play the one tune there is.
+		s.songHeader.Songs = 1
+	}
+	s.playAddress = s.songHeader.PlayAddress
 	s.currentSong = s.songHeader.StartSong - 1
 	s.isLoaded = true
+	s.setVideoStandard(videoStandardFromHeader(s.songHeader))
+	s.model, s.modelSource = s.sidModelFromHeader(0)
 
 	return true
 }
@@ -106,7 +191,7 @@ func (s *SidPlayer) updateFramePeriod() {
 		s.frameRate = NTSC_FRAMERATE
 	}
 
-	s.framePeriod = s.clockFreq / uint32(s.frameRate)
+	s.framePeriod = uint32(float64(s.clockFreq) / s.frameRate)
 }
 
 func (s *SidPlayer) setSampleRate(freq uint32) {
@@ -118,8 +203,298 @@ func (s *SidPlayer) setSampleRate(freq uint32) {
 	}
 }
 
+func (s *SidPlayer) setSamplingMethod(method resid.SamplingMethod) {
+	s.sampling = method
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// applySamplingParameters configures the SID for the current clock, sample
+// rate and sampling method. Resampling is not possible at very low sample
+// rates, in which case the fast method is used instead.
+func (s *SidPlayer) applySamplingParameters() {
+	if !s.sid.SetSamplingParameters(float64(s.clockFreq), s.sampling, float64(s.sampleFreq)) {
+		fmt.Fprintf(messages, "Warning: sampling method %d not possible at %d Hz, using fast sampling\n", s.sampling, s.sampleFreq)
+		s.sampling = resid.SAMPLE_FAST
+		s.sid.SetSamplingParameters(float64(s.clockFreq), s.sampling, float64(s.sampleFreq))
+	}
+}
+
+func (s *SidPlayer) setFilterType(filterType resid.FilterType) {
+	s.filterType = filterType
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+func (s *SidPlayer) setDigiBoost(enable bool) {
+	s.digiBoost = enable
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// setExternalFilter selects the output stage by board preset. Corner
+// frequencies that are not negative override those of the preset, 0 leaving
+// out the filter.
+func (s *SidPlayer) setExternalFilter(name string, highPass float64, lowPass float64) {
+	s.extFilter = name
+	s.extFilterHP = highPass
+	s.extFilterLP = lowPass
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+func (s *SidPlayer) applyExternalFilter() {
+	model, ok := resid.ExternalFilterModelByName(s.extFilter)
+	if !ok {
+		fmt.Fprintf(messages, "Warning: unknown output filter %q, using c64\n", s.extFilter)
+		model = resid.EXTFILTER_C64
+	}
+	if s.extFilterHP >= 0 {
+		model.HighPass = s.extFilterHP
+	}
+	if s.extFilterLP >= 0 {
+		model.LowPass = s.extFilterLP
+	}
+	s.sid.SetExternalFilter(model)
+	s.sid.SetOutputClip(s.outputClip)
+
+	if model.HighPass == 0 && model.LowPass == 0 {
+		fmt.Fprintf(messages, "Output filter = none\n")
+	} else {
+		fmt.Fprintf(messages, "Output filter = %s (high-pass %.1f Hz, low-pass %.0f Hz)\n", model.Name, model.HighPass, model.LowPass)
+	}
+}
+
+func (s *SidPlayer) setOutputClip(clip resid.OutputClip) {
+	s.outputClip = clip
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+func (s *SidPlayer) setStemRecorder(stems *StemRecorder) {
+	s.stems = stems
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// closeStems finishes the stem recording.
+func (s *SidPlayer) closeStems() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stems == nil {
+		return nil
+	}
+	err := s.stems.Close()
+	s.stems = nil
+	s.sid.EnableTaps(false)
+	return err
+}
+
+func (s *SidPlayer) setExternalInput(input *ExternalInput) {
+	s.extInput = input
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// applyExternalInput sets up the EXT IN pin, fed by a recording and/or
+// digi boost.
+//
+// Digi boost feeds a DC level into EXT IN on the 8580, like the digi boost
+// hardware hack. The level is modulated by the volume register, which makes
+// volume register samples audible; the 6581 has a DC offset in the mixer
+// that does the same.
+func (s *SidPlayer) applyExternalInput() {
+	level := 0
+	if s.digiBoost && s.model == resid.MOS8580 {
+		level = DIGIBOOST_LEVEL
+		fmt.Fprintln(messages, "Digi boost = on")
+	}
+
+	if s.extInput != nil {
+		s.extInput.offset = level
+		s.sid.SetInputSource(s.extInput)
+		fmt.Fprintf(messages, "External input = %d Hz, %d samples\n", s.extInput.wav.SampleRate, len(s.extInput.wav.Samples))
+		return
+	}
+	s.sid.SetInputSource(nil)
+	if level != 0 {
+		s.sid.Input(DIGIBOOST_LEVEL)
+	} else {
+		s.sid.Input(0)
+	}
+}
+
+func (s *SidPlayer) setWaveTables(fileName string) {
+	s.waveTables = fileName
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// applyWaveTables loads the combined waveform tables from file. Without
+// one the tables of the SID model are used.
+func (s *SidPlayer) applyWaveTables() {
+	if s.waveTables == "" {
+		s.sid.SetWaveTables(nil)
+		return
+	}
+
+	tables, err := loadWaveTables(s.waveTables)
+	if err == nil {
+		err = s.sid.SetWaveTables(tables)
+	}
+	if err != nil {
+		fmt.Fprintf(messages, "Warning: cannot load wave tables %q (%v), using the Sid model tables\n", s.waveTables, err)
+		s.sid.SetWaveTables(nil)
+		return
+	}
+	fmt.Fprintf(messages, "Wave tables = %s\n", tables.Name)
+}
+
+func loadWaveTables(fileName string) (*resid.WaveTables, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return resid.LoadWaveTables(file, filepath.Base(fileName))
+}
+
+func (s *SidPlayer) setFilterCurve(name string) {
+	s.filterCurve = name
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// applyFilterCurve sets the filter cutoff curve, either a built-in preset
+// or a curve file. Without one the curve of the SID model is used.
+func (s *SidPlayer) applyFilterCurve() {
+	if s.filterCurve == "" {
+		s.sid.SetFilterCurve(nil)
+		return
+	}
+
+	curve, ok := resid.FilterCurveByName(s.filterCurve)
+	if !ok {
+		var err error
+		curve, err = loadFilterCurve(s.filterCurve)
+		if err != nil {
+			fmt.Fprintf(messages, "Warning: cannot load filter curve %q (%v), using the Sid model curve\n", s.filterCurve, err)
+			s.sid.SetFilterCurve(nil)
+			return
+		}
+	}
+	s.sid.SetFilterCurve(curve)
+	fmt.Fprintf(messages, "Filter curve = %s\n", curve.Name)
+}
+
+func loadFilterCurve(fileName string) (*resid.FilterCurve, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return resid.LoadFilterCurve(file, filepath.Base(fileName))
+}
+
+func (s *SidPlayer) setDACModel(name string) {
+	s.dacModel = name
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// applyDACModel configures the SID DACs. The model "chip" follows the SID
+// model in use.
+func (s *SidPlayer) applyDACModel() {
+	name := s.dacModel
+	if name == "chip" {
+		name = "6581"
+		if s.model == resid.MOS8580 {
+			name = "8580"
+		}
+	}
+
+	dac, ok := resid.DacModelByName(name)
+	if !ok {
+		fmt.Fprintf(messages, "Warning: unknown DAC model %q, using ideal DACs\n", s.dacModel)
+		dac = resid.DAC_IDEAL
+	}
+	s.sid.SetDACModel(dac)
+	fmt.Fprintf(messages, "DAC model = %s\n", dac.Name)
+}
+
 func (s *SidPlayer) setSIDModel(model resid.Model) {
 	s.model = model
+	s.modelSource = "forced"
+	s.isInitialized = false
+
+	if s.isPlaying {
+		s.Start()
+	}
+}
+
+// setPreferredSIDModel sets the model used for tunes that play on either
+// model or do not say which one they were made for.
+func (s *SidPlayer) setPreferredSIDModel(model resid.Model) {
+	s.modelDefault = model
+}
+
+// sidModelFromHeader picks the model for SID chip 0-2 from the tune header
+// and returns it together with a description of where the choice came from.
+func (s *SidPlayer) sidModelFromHeader(chip int) (resid.Model, string) {
+	switch s.songHeader.SidModel(chip) {
+	case psid.MODEL_6581:
+		return resid.MOS6581, "tune header"
+	case psid.MODEL_8580:
+		return resid.MOS8580, "tune header"
+	case psid.MODEL_ANY:
+		return s.modelDefault, "either model, preferred default"
+	default:
+		return s.modelDefault, "unknown model, preferred default"
+	}
+}
+
+// setVideoStandard selects the clock and raster timing the tune is played
+// with. It drives the CPU cycles per frame and the SID clock, and thereby
+// the pitch.
+func (s *SidPlayer) setVideoStandard(video VideoStandard) {
+	timing := video.Timing()
+	s.video = video
+	s.clockFreq = timing.ClockFreq
+	s.frameRate = timing.FrameRate()
+	s.framePeriod = timing.FramePeriod()
 	s.isInitialized = false
 
 	if s.isPlaying {
@@ -127,16 +502,63 @@ func (s *SidPlayer) setSIDModel(model resid.Model) {
 	}
 }
 
-// func (s *SidPlayer) nextTune() {
-// 	s.playTune(uint16(s.currentSong + 1))
-// }
+// PlayTune switches to subtune num, counting from 0, starting it from the
+// memory image as loaded.
+func (s *SidPlayer) PlayTune(num int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.playTune(num)
+}
+
+// NextTune switches to the next subtune, wrapping around after the last.
+func (s *SidPlayer) NextTune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isLoaded {
+		return errors.New("no tune loaded")
+	}
+	return s.playTune((int(s.currentSong) + 1) % int(s.songHeader.Songs))
+}
 
-// func (s *SidPlayer) playTune(num uint16) {
-// 	s.currentSong = num
-// 	if s.isPlaying {
-// 		s.Start()
-// 	}
-// }
+// PrevTune switches to the previous subtune, wrapping around before the
+// first.
+func (s *SidPlayer) PrevTune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isLoaded {
+		return errors.New("no tune loaded")
+	}
+	songs := int(s.songHeader.Songs)
+	return s.playTune((int(s.currentSong) + songs - 1) % songs)
+}
+
+// Tune returns the current subtune, counting from 0, and the number of
+// subtunes, 0 if no tune is loaded.
+func (s *SidPlayer) Tune() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isLoaded {
+		return 0, 0
+	}
+	return int(s.currentSong), int(s.songHeader.Songs)
+}
+
+func (s *SidPlayer) playTune(num int) error {
+	if !s.isLoaded {
+		return errors.New("no tune loaded")
+	}
+	if num < 0 || num >= int(s.songHeader.Songs) {
+		return fmt.Errorf("no subtune %d, the tune has %d", num, s.songHeader.Songs)
+	}
+
+	s.currentSong = uint16(num)
+	s.Start()
+	return nil
+}
 
 func (s *SidPlayer) Start() {
 	if !s.isInitialized {
@@ -145,24 +567,41 @@ func (s *SidPlayer) Start() {
 		s.Reset()
 	}
 
-	s.sid.SetSamplingParameters(float64(s.clockFreq), resid.SAMPLE_FAST, float64(s.sampleFreq))
-	s.delta_t = (s.clockFreq / s.sampleFreq)
-	s.framePeriod = s.clockFreq / uint32(s.frameRate)
+	s.applySamplingParameters()
+	timing := s.video.Timing()
+	s.frameRate = timing.FrameRate()
+	s.framePeriod = timing.FramePeriod()
+	lastLine := byte(timing.LinesPerFrame - 0x100)
 
+	// Each subtune starts from the memory image as loaded.
+	s.mem.b = s.image
+	s.songHeader.PlayAddress = s.playAddress
+	s.cpu.Reg.Init()
 	s.cpu.Mem.StoreByte(0x01, 0x37)
 
 	if s.currentSong >= s.songHeader.Songs {
 		s.currentSong = 0
 	}
 
-	fmt.Printf("Playing subtune %d\n", s.currentSong)
+	fmt.Fprintf(messages, "Playing subtune %d\n", s.currentSong)
+	s.sidWrites = s.sidWrites[:0]
+	s.elapsedCycles = 0
+	if s.rewind != nil {
+		s.rewind.clear()
+	}
+	s.paddleNext = 0
+	if s.extInput != nil {
+		s.extInput.setClock(s.clockFreq)
+		s.extInput.rewind()
+	}
+	s.sid.SetPaddles(s.potx, s.poty)
 	s.initCPU(s.songHeader.InitAddress, uint8(s.currentSong), 0, 0)
 	instr := 0
 
 	for !s.runCPU() {
 		s.mem.StoreByte(0xD012, s.mem.LoadByte(0xD012)+1)
 
-		if (s.cpu.Mem.LoadByte(0xD012) == 0) || (((s.cpu.Mem.LoadByte(0xD011) & 0x80) != 0) && (s.cpu.Mem.LoadByte(0xd012) >= 0x38)) {
+		if (s.cpu.Mem.LoadByte(0xD012) == 0) || (((s.cpu.Mem.LoadByte(0xD011) & 0x80) != 0) && (s.cpu.Mem.LoadByte(0xd012) >= lastLine)) {
 			tmp := s.cpu.Mem.LoadByte(0xD011)
 			tmp ^= 0x80
 			s.cpu.Mem.StoreByte(0xD011, tmp)
@@ -171,26 +610,27 @@ func (s *SidPlayer) Start() {
 		instr += 1
 
 		if instr > int(MAX_INSTR) {
-			fmt.Println("Warning: CPU executed a high number of instructions in init, breaking")
+			fmt.Fprintln(messages, "Warning: CPU executed a high number of instructions in init, breaking")
 			break
 		}
 	}
 
 	if s.songHeader.PlayAddress == 0 {
-		fmt.Println("Warning: SID has play address 0, reading from interrupt vector instead")
+		fmt.Fprintln(messages, "Warning: SID has play address 0, reading from interrupt vector instead")
 		if s.cpu.Mem.LoadByte(0x01)&0x07 == 0x5 {
 			s.songHeader.PlayAddress = uint16(s.cpu.Mem.LoadByte(0xFFFE)) | (uint16(s.cpu.Mem.LoadByte(0xFFFF)) << 8)
 		} else {
 			s.songHeader.PlayAddress = uint16(s.cpu.Mem.LoadByte(0x314)) | (uint16(s.cpu.Mem.LoadByte(0x315)) << 8)
 		}
-		fmt.Printf("New play address is $%04X\n", s.songHeader.PlayAddress)
+		fmt.Fprintf(messages, "New play address is $%04X\n", s.songHeader.PlayAddress)
 	}
 
 	s.updateFramePeriod()
+	s.frameCycles = resid.CycleCount(s.framePeriod)
 
 	speedflag := (s.songHeader.Speed&(1<<s.currentSong) != 0)
-	fmt.Printf("cpu_clk: %d[Hz] samplerate: %d[Hz] samples/frame: %d frame period: %d[us] delta_t: %d timing: %t\n",
-		s.clockFreq, s.sampleFreq, s.framePeriod/s.delta_t, s.framePeriod, s.delta_t, speedflag)
+	fmt.Fprintf(messages, "cpu_clk: %d[Hz] samplerate: %d[Hz] samples/frame: %.2f frame period: %d[cycles] timing: %t\n",
+		s.clockFreq, s.sampleFreq, float64(s.framePeriod)*float64(s.sampleFreq)/float64(s.clockFreq), s.framePeriod, speedflag)
 
 	// audio_start();
 	s.isPlaying = true
@@ -236,6 +676,15 @@ func (s *SidPlayer) runCPU() bool {
 }
 
 func (s *SidPlayer) Tick() {
+	// Writes left over from a play routine running longer than a frame.
+	s.flushSidWrites()
+
+	// Queue SID writes with their cycle offsets.
+	s.queueWrites = true
+	s.tickCycles = s.cpu.Cycles
+	s.frameClock = 0
+	defer func() { s.queueWrites = false }()
+
 	// Run the playroutine
 	instr := 0
 	s.initCPU(s.songHeader.PlayAddress, 0, 0, 0)
@@ -244,7 +693,7 @@ func (s *SidPlayer) Tick() {
 		instr += 1
 
 		if instr > int(MAX_INSTR) {
-			fmt.Println("Warning: CPU executed a high number of instructions in init, breaking")
+			fmt.Fprintln(messages, "Warning: CPU executed a high number of instructions in init, breaking")
 			break
 		}
 
@@ -261,6 +710,164 @@ func (s *SidPlayer) Tick() {
 
 }
 
+// Render fills buf with mono samples, running the play routine each time
+// a frame worth of cycles has been clocked through the SID. The register
+// writes of the play routine are applied at the cycle they were done, so
+// e.g. volume register samples play at the right rate.
+func (s *SidPlayer) Render(buf []int16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.render(buf)
+}
+
+func (s *SidPlayer) render(buf []int16) {
+	for i := 0; i < len(buf); {
+		if s.frameCycles <= 0 {
+			s.startFrame()
+		}
+
+		delta_t := s.nextClock()
+		clocked := delta_t
+		if s.stems != nil {
+			taps := s.stems.tapBuffer(len(buf) - i)
+			n := s.sid.ClockBufferTaps(&delta_t, buf[i:], taps)
+			s.recordStems(buf[i:i+n], taps[:n])
+			i += n
+		} else {
+			i += s.sid.ClockBuffer(&delta_t, buf[i:])
+		}
+		s.advance(clocked - delta_t)
+	}
+}
+
+// startFrame runs the play routine for the next frame.
+func (s *SidPlayer) startFrame() {
+	s.recordRewind()
+	s.applyPaddleScript()
+	s.Tick()
+	if s.framePeriod == 0 {
+		s.framePeriod = s.video.Timing().FramePeriod()
+	}
+	s.frameCycles += resid.CycleCount(s.framePeriod)
+}
+
+// nextClock returns the cycles to clock up to the end of the frame or the
+// next queued write.
+func (s *SidPlayer) nextClock() resid.CycleCount {
+	delta_t := s.frameCycles
+	if len(s.sidWrites) > 0 {
+		delta_t = min(delta_t, s.sidWrites[0].cycle-s.frameClock)
+	}
+	return delta_t
+}
+
+// advance accounts for clocked cycles and applies the queued writes that
+// have become due.
+func (s *SidPlayer) advance(clocked resid.CycleCount) {
+	s.frameCycles -= clocked
+	s.frameClock += clocked
+	s.elapsedCycles += uint64(clocked)
+
+	for len(s.sidWrites) > 0 && s.sidWrites[0].cycle <= s.frameClock {
+		s.sid.Write(s.sidWrites[0].reg, s.sidWrites[0].value)
+		s.sidWrites = s.sidWrites[1:]
+	}
+}
+
+// recordStems writes samples to the stem recording. The recording stops at
+// the first error.
+func (s *SidPlayer) recordStems(mix []int16, taps [][3]resid.VoiceTap) {
+	if err := s.stems.write(mix, taps); err != nil {
+		fmt.Fprintf(messages, "Warning: stem recording stopped (%v)\n", err)
+		s.stems.Close()
+		s.stems = nil
+		s.sid.EnableTaps(false)
+	}
+}
+
+// Elapsed returns the playing time of the current subtune.
+func (s *SidPlayer) Elapsed() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.elapsed()
+}
+
+func (s *SidPlayer) elapsed() time.Duration {
+	return time.Duration(float64(s.elapsedCycles) / float64(s.clockFreq) * float64(time.Second))
+}
+
+// State returns a snapshot of the SID voices and filter, safe to read from
+// another goroutine while playing.
+func (s *SidPlayer) State() resid.SidState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sid.State()
+}
+
+// SetPaddles sets the paddle positions read from POTX and POTY.
+func (s *SidPlayer) SetPaddles(x uint8, y uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.potx, s.poty = x, y
+	s.sid.SetPaddles(x, y)
+}
+
+// Paddles returns the paddle positions.
+func (s *SidPlayer) Paddles() (uint8, uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.potx, s.poty
+}
+
+// MoveMouse moves a 1351 mouse in proportional mode, which reports the
+// position modulo 64 in bits 1-6 of POTX and POTY.
+func (s *SidPlayer) MoveMouse(dx int, dy int) {
+	s.mu.Lock()
+	s.mouseX += dx
+	s.mouseY += dy
+	x, y := uint8(s.mouseX&0x3f)<<1, uint8(s.mouseY&0x3f)<<1
+	s.mu.Unlock()
+
+	s.SetPaddles(x, y)
+}
+
+func (s *SidPlayer) setPaddleScript(script []paddleEvent) {
+	s.paddleScript = script
+	s.paddleNext = 0
+}
+
+// applyPaddleScript sets the paddle positions of the script entries that
+// are due.
+func (s *SidPlayer) applyPaddleScript() {
+	elapsed := s.elapsed()
+	for ; s.paddleNext < len(s.paddleScript) && s.paddleScript[s.paddleNext].time <= elapsed; s.paddleNext++ {
+		e := s.paddleScript[s.paddleNext]
+		s.potx, s.poty = e.x, e.y
+		s.sid.SetPaddles(e.x, e.y)
+	}
+}
+
+// flushSidWrites applies all queued SID writes.
+func (s *SidPlayer) flushSidWrites() {
+	for _, w := range s.sidWrites {
+		s.sid.Write(w.reg, w.value)
+	}
+	s.sidWrites = s.sidWrites[:0]
+}
+
+// writeCycle returns the cycle offset into the frame of a write by the
+// instruction being executed. The write is done in the last cycle of the
+// instruction.
+func (s *SidPlayer) writeCycle() resid.CycleCount {
+	inst := s.cpu.InstSet.Lookup(s.mem.LoadByte(s.cpu.LastPC))
+	return resid.CycleCount(s.cpu.Cycles-s.tickCycles) + resid.CycleCount(inst.Cycles) - 1
+}
+
 func (s *SidPlayer) Quit() {
 	// audio_quit()
 }
@@ -269,19 +876,35 @@ func (s *SidPlayer) Quit() {
 func (s *SidPlayer) OnWrite(addr uint16, v byte) {
 	if addr >= 0xD400 && addr <= 0xD418 {
 		// fmt.Printf("Sid reg update %X=%X\n", addr, v)
+		if s.queueWrites {
+			s.sidWrites = append(s.sidWrites, sidWrite{s.writeCycle(), uint8(addr - 0xD400), v})
+			return
+		}
 		s.sid.Write(uint8(addr-0xD400), v)
 	}
 }
 
+// OnRead is called when the CPU reads a SID register, and returns the value
+// read. The chip is clocked up to the start of the frame, so the registers
+// read back as they were then.
+func (s *SidPlayer) OnRead(addr uint16) byte {
+	return s.sid.Read(uint8(addr & 0x1f))
+}
+
 type WriteNotification interface {
 	OnWrite(addr uint16, v byte)
 }
 
+type ReadNotification interface {
+	OnRead(addr uint16) byte
+}
+
 // FlatMemory represents an entire 16-bit address space as a singular
 // 64K buffer.
 type FlatMemoryWithNotification struct {
 	b           [64 * 1024]byte
 	writeNotify WriteNotification
+	readNotify  ReadNotification
 }
 
 // AttachWriteNotifier attaches a handler that is called whenever a store
@@ -290,6 +913,12 @@ func (m *FlatMemoryWithNotification) AttachWriteNotifier(handler WriteNotificati
 	m.writeNotify = handler
 }
 
+// AttachReadNotifier attaches a handler that supplies the value of loads
+// from the SID registers at $D400-$D41F.
+func (m *FlatMemoryWithNotification) AttachReadNotifier(handler ReadNotification) {
+	m.readNotify = handler
+}
+
 // FlatMemoryWithNotification creates a new 16-bit memory space with a
 // a notification mechanism to trigger writes to the Resid component too.
 func NewFlatMemoryWithNotification() *FlatMemoryWithNotification {
@@ -299,6 +928,9 @@ func NewFlatMemoryWithNotification() *FlatMemoryWithNotification {
 
 // LoadByte loads a single byte from the address and returns it.
 func (m *FlatMemoryWithNotification) LoadByte(addr uint16) byte {
+	if addr&0xffe0 == 0xd400 && m.readNotify != nil {
+		return m.readNotify.OnRead(addr)
+	}
 	return m.b[addr]
 }
 
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	resid "yaspg/app/sid"
+)
+
+// writeTestTune writes a PSID file with the code loaded at $1000, an init
+// routine at $1000 and a play routine at $1001.
+func writeTestTune(t *testing.T, code []byte) string {
+	t.Helper()
+
+	header := make([]byte, 0x7c)
+	copy(header, "PSID")
+	binary.BigEndian.PutUint16(header[0x04:], 2)      // version
+	binary.BigEndian.PutUint16(header[0x06:], 0x7c)   // data offset
+	binary.BigEndian.PutUint16(header[0x08:], 0x1000) // load address
+	binary.BigEndian.PutUint16(header[0x0a:], 0x1000) // init address
+	binary.BigEndian.PutUint16(header[0x0c:], 0x1001) // play address
+	binary.BigEndian.PutUint16(header[0x0e:], 1)      // songs
+	binary.BigEndian.PutUint16(header[0x10:], 1)      // start song
+	copy(header[0x16:], "test")
+	binary.BigEndian.PutUint16(header[0x76:], 0x14) // PAL, 6581
+
+	fileName := filepath.Join(t.TempDir(), "test.sid")
+	if err := os.WriteFile(fileName, append(header, code...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+// A play routine toggling the volume register between 15 and 0, 16 times a
+// frame at ~4.5 kHz, with all voices silent. The play routine runs at once,
+// so the digi is only heard if the writes are applied at their cycle.
+var volumeDigiTune = []byte{
+	0x60,       // $1000 init: RTS
+	0xa2, 0x10, // $1001 play: LDX #16
+	0xa9, 0x0f, // loop: LDA #$0F
+	0x8d, 0x18, 0xd4, // STA $D418
+	0xa0, 0x14, // LDY #20
+	0x88,       // DEY
+	0xd0, 0xfd, // BNE *-1
+	0xa9, 0x00, // LDA #$00
+	0x8d, 0x18, 0xd4, // STA $D418
+	0xa0, 0x14, // LDY #20
+	0x88,       // DEY
+	0xd0, 0xfd, // BNE *-1
+	0xca,       // DEX
+	0xd0, 0xe9, // BNE loop
+	0x60, // RTS
+}
+
+// renderDigi renders a second of the volume register digi and returns the
+// RMS level of the output around its mean.
+func renderDigi(t *testing.T, model resid.Model, digiBoost bool) float64 {
+	player := NewSidPlayer()
+	player.setDigiBoost(digiBoost)
+	player.Load(writeTestTune(t, volumeDigiTune))
+	player.setSIDModel(model)
+	player.Init()
+	player.Start()
+	defer player.Stop()
+
+	buf := make([]int16, player.sampleFreq)
+	player.Render(buf)
+
+	// Leave out the settling of the output filter.
+	samples := buf[len(buf)/4:]
+	var sum, sum2 float64
+	for _, v := range samples {
+		sum += float64(v)
+		sum2 += float64(v) * float64(v)
+	}
+	mean := sum / float64(len(samples))
+	return math.Sqrt(sum2/float64(len(samples)) - mean*mean)
+}
+
+func TestVolumeRegisterDigi(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     resid.Model
+		digiBoost bool
+		audible   bool
+	}{
+		{"6581", resid.MOS6581, false, true},
+		{"6581 with digi boost", resid.MOS6581, true, true},
+		{"8580", resid.MOS8580, false, false},
+		{"8580 with digi boost", resid.MOS8580, true, true},
+	}
+
+	for _, test := range tests {
+		level := renderDigi(t, test.model, test.digiBoost)
+		t.Logf("%s: RMS level %.1f", test.name, level)
+		if test.audible && level < 1000 {
+			t.Errorf("%s: RMS level %.1f, want an audible digi", test.name, level)
+		}
+		if !test.audible && level > 10 {
+			t.Errorf("%s: RMS level %.1f, want near silence", test.name, level)
+		}
+	}
+}
+
+// The seek command runs on the terminal goroutine while the audio callback
+// renders. Run with -race.
+func TestSeekWhileRendering(t *testing.T) {
+	player := NewSidPlayer()
+	player.Load(writeTestTune(t, volumeDigiTune))
+	player.Init()
+	player.Start()
+	defer player.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]int16, 512)
+		for i := 0; i < 100; i++ {
+			player.Render(buf)
+		}
+	}()
+	for _, arg := range []string{"3", "1", "0:02"} {
+		if err := commands["seek"].run(player, []string{arg}); err != nil {
+			t.Error(err)
+		}
+	}
+	<-done
+
+	if got := player.Elapsed(); got < 2*time.Second {
+		t.Errorf("elapsed %v after seeking to 2 seconds", got)
+	}
+}
+
+func TestSwitchTunes(t *testing.T) {
+	player := NewSidPlayer()
+	if err := player.NextTune(); err == nil {
+		t.Error("next subtune without a tune loaded")
+	}
+	if err := player.PrevTune(); err == nil {
+		t.Error("previous subtune without a tune loaded")
+	}
+
+	// A tune claiming no subtunes is played as one.
+	fileName := writeTestTune(t, volumeDigiTune)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint16(data[0x0e:], 0)
+	if err := os.WriteFile(fileName, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	player.Load(fileName)
+	player.Init()
+	player.Start()
+	defer player.Stop()
+
+	for _, next := range []func() error{player.NextTune, player.PrevTune} {
+		if err := next(); err != nil {
+			t.Fatal(err)
+		}
+		if num, songs := player.Tune(); num != 0 || songs != 1 {
+			t.Errorf("subtune %d of %d, want 0 of 1", num, songs)
+		}
+	}
+}
+
+// A play routine copying POTX and POTY to $C000 and $C001.
+var paddleTune = []byte{
+	0x60,             // $1000 init: RTS
+	0xad, 0x19, 0xd4, // $1001 play: LDA $D419
+	0x8d, 0x00, 0xc0, // STA $C000
+	0xad, 0x1a, 0xd4, // LDA $D41A
+	0x8d, 0x01, 0xc0, // STA $C001
+	0x60, // RTS
+}
+
+func TestReadPaddles(t *testing.T) {
+	player := NewSidPlayer()
+	player.Load(writeTestTune(t, paddleTune))
+	player.Init()
+	player.Start()
+	defer player.Stop()
+
+	buf := make([]int16, player.sampleFreq/10)
+	for _, paddles := range [][2]uint8{{0x42, 0x99}, {0xff, 0x00}} {
+		player.SetPaddles(paddles[0], paddles[1])
+		player.Render(buf)
+		if x, y := player.mem.b[0xc000], player.mem.b[0xc001]; x != paddles[0] || y != paddles[1] {
+			t.Errorf("tune read POTX = %#02x, POTY = %#02x, want %#02x, %#02x", x, y, paddles[0], paddles[1])
+		}
+	}
+}
@@ -23,26 +23,87 @@ type PSIDHeader struct {
 	Name        [32]byte
 	Author      [32]byte
 	Released    [32]byte
+
+	// PSID v2+ fields, zero for v1 headers.
+	Flags            uint16
+	StartPage        uint8
+	PageLength       uint8
+	SecondSIDAddress uint8
+	ThirdSIDAddress  uint8
 }
 
+// Video clock the tune was made for, bits 2-3 of the v2+ header flags.
+const (
+	CLOCK_UNKNOWN uint8 = iota
+	CLOCK_PAL
+	CLOCK_NTSC
+	CLOCK_ANY
+)
+
+// SID model the tune was made for, two bits per chip in the v2+ header flags.
+const (
+	MODEL_UNKNOWN uint8 = iota
+	MODEL_6581
+	MODEL_8580
+	MODEL_ANY
+)
+
 func NewPSID() *PSIDHeader {
 	psid := &PSIDHeader{}
 	return psid
 }
 
-func (psid *PSIDHeader) PrintHeader() {
-	fmt.Printf("MagicID:  %s\n", psid.MagicID)
-	fmt.Printf("Version:  %X\n", psid.Version)
-	fmt.Printf("DataOffset:  0x%X\n", psid.DataOffset)
-	fmt.Printf("LoadAddress: 0x%X\n", psid.LoadAddress)
-	fmt.Printf("InitAddress: 0x%X\n", psid.InitAddress)
-	fmt.Printf("PlayAddress: 0x%X\n", psid.PlayAddress)
-	fmt.Printf("Songs: %d\n", psid.Songs)
-	fmt.Printf("Startsong: %d\n", psid.StartSong)
-	fmt.Printf("Speed: 0x%X\n", psid.Speed)
-	fmt.Printf("Name: %s\n", psid.Name)
-	fmt.Printf("Author: %s\n", psid.Author)
-	fmt.Printf("Copyright: %s\n", psid.Released)
+func (psid *PSIDHeader) PrintHeader(w io.Writer) {
+	fmt.Fprintf(w, "MagicID:  %s\n", psid.MagicID)
+	fmt.Fprintf(w, "Version:  %X\n", psid.Version)
+	fmt.Fprintf(w, "DataOffset:  0x%X\n", psid.DataOffset)
+	fmt.Fprintf(w, "LoadAddress: 0x%X\n", psid.LoadAddress)
+	fmt.Fprintf(w, "InitAddress: 0x%X\n", psid.InitAddress)
+	fmt.Fprintf(w, "PlayAddress: 0x%X\n", psid.PlayAddress)
+	fmt.Fprintf(w, "Songs: %d\n", psid.Songs)
+	fmt.Fprintf(w, "Startsong: %d\n", psid.StartSong)
+	fmt.Fprintf(w, "Speed: 0x%X\n", psid.Speed)
+	fmt.Fprintf(w, "Name: %s\n", psid.Name)
+	fmt.Fprintf(w, "Author: %s\n", psid.Author)
+	fmt.Fprintf(w, "Copyright: %s\n", psid.Released)
+	if psid.Version >= 2 {
+		fmt.Fprintf(w, "Flags: 0x%X\n", psid.Flags)
+	}
+}
+
+// Clock returns the video clock flag of the tune, CLOCK_UNKNOWN for v1 headers.
+func (psid *PSIDHeader) Clock() uint8 {
+	if psid.Version < 2 {
+		return CLOCK_UNKNOWN
+	}
+	return uint8(psid.Flags>>2) & 0x03
+}
+
+// SidModel returns the model flag of SID chip 0-2, MODEL_UNKNOWN for chips
+// the header version does not describe. The second and third chip use the
+// model of the first one if their own bits are not set.
+func (psid *PSIDHeader) SidModel(chip int) uint8 {
+	if chip < 0 || chip > 2 || int(psid.Version) < 2+chip {
+		return MODEL_UNKNOWN
+	}
+	model := uint8(psid.Flags>>(4+2*chip)) & 0x03
+	if model == MODEL_UNKNOWN && chip > 0 {
+		return psid.SidModel(0)
+	}
+	return model
+}
+
+// Text returns a name, author or released field of the header as UTF-8. The
+// fields are ISO 8859-1, padded with zero bytes.
+func Text(field [32]byte) string {
+	runes := make([]rune, 0, len(field))
+	for _, b := range field {
+		if b == 0 {
+			break
+		}
+		runes = append(runes, rune(b))
+	}
+	return string(runes)
 }
 
 func (psid *PSIDHeader) LoadHeader(file *os.File) error {
@@ -52,6 +113,13 @@ func (psid *PSIDHeader) LoadHeader(file *os.File) error {
 		return errors.New("not a valid psid file")
 	}
 
+	// The v2+ fields were read from the start of the data in a v1 file.
+	if psid.Version < 2 {
+		psid.Flags = 0
+		psid.StartPage, psid.PageLength = 0, 0
+		psid.SecondSIDAddress, psid.ThirdSIDAddress = 0, 0
+	}
+
 	file.Seek(int64(psid.DataOffset), 0)
 	if psid.LoadAddress == 0 {
 		psid.LoadAddress = uint16(readByte(file)) | uint16(readByte(file))<<8
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	psid "yaspg/app/psid"
+)
+
+// Playing time rendered when neither a time nor a Songlengths entry is given.
+const RENDER_TIME = 3 * time.Minute
+
+// Peak to peak level up to which the output counts as silent, allowing for
+// the DC offset left by the filters.
+const SILENCE_LEVEL = 16
+
+// sampleWriter is a file the render command writes to.
+type sampleWriter interface {
+	Write(samples []int16) error
+	Close() error
+}
+
+// silenceDetector tracks the length of the silence at the end of the output,
+// counting only after the tune has made a sound.
+type silenceDetector struct {
+	heard    bool
+	samples  int
+	min, max int16
+}
+
+// add adds a sample, and returns the number of silent samples up to it.
+func (d *silenceDetector) add(v int16) int {
+	if d.samples == 0 {
+		d.min, d.max = v, v
+	}
+	d.min, d.max = min(d.min, v), max(d.max, v)
+	if int(d.max)-int(d.min) > SILENCE_LEVEL {
+		d.heard = true
+		d.samples = 0
+		d.min, d.max = v, v
+	}
+	d.samples++
+	if !d.heard {
+		return 0
+	}
+	return d.samples
+}
+
+// renderTune plays the current subtune faster than real time into a WAV or
+// FLAC file, by the extension. It stops after the time to render, by
+// default the Songlengths entry of the subtune, or after a stretch of
+// silence. The player must be started.
+func renderTune(player *SidPlayer, r *RenderSettings, sidName string) error {
+	if r.OutputFile == "" {
+		return errors.New("expected an output file, -o <file>")
+	}
+
+	length := RENDER_TIME
+	switch {
+	case r.Time != "":
+		t, err := parseTime(r.Time)
+		if err != nil {
+			return err
+		}
+		length = t
+	case r.SongLengths != "":
+		lengths, err := LoadSongLengths(r.SongLengths)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(sidName)
+		if err != nil {
+			return err
+		}
+		num, _ := player.Tune()
+		if t, ok := lengths.Lookup(data, num); ok {
+			length = t
+		} else {
+			fmt.Fprintf(messages, "Warning: subtune %d not in %s, rendering %s\n", num, r.SongLengths, formatTime(length))
+		}
+	}
+
+	file, err := os.Create(r.OutputFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	channels := uint16(1)
+	if r.Stereo {
+		channels = 2
+	}
+	var out sampleWriter
+	switch {
+	case strings.EqualFold(filepath.Ext(r.OutputFile), ".flac"):
+		if r.Float {
+			return errors.New("FLAC holds 16-bit samples, not float")
+		}
+		out, err = NewFlacWriter(file, player.sampleFreq, channels, tuneComments(player))
+	case r.Float:
+		out, err = NewFloatWavWriter(file, player.sampleFreq, channels)
+	default:
+		out, err = NewWavWriter(file, player.sampleFreq, channels)
+	}
+	if err != nil {
+		return err
+	}
+
+	start := player.Elapsed()
+	total := int((length - start).Seconds() * float64(player.sampleFreq))
+	silence := int(r.Silence * float64(player.sampleFreq))
+
+	var detector silenceDetector
+	buf := make([]int16, 4096)
+	frames := make([]int16, 0, 2*len(buf))
+	rendered := 0
+	for rendered < total {
+		samples := buf[:min(len(buf), total-rendered)]
+		player.Render(samples)
+		rendered += len(samples)
+
+		frames = frames[:0]
+		silent := 0
+		for _, v := range samples {
+			frames = append(frames, v)
+			if r.Stereo {
+				frames = append(frames, v)
+			}
+			silent = detector.add(v)
+		}
+		if err := out.Write(frames); err != nil {
+			return err
+		}
+
+		if silence > 0 && silent >= silence {
+			fmt.Fprintf(messages, "Silent since %s\n", formatTime(player.Elapsed()-time.Duration(float64(silent)/float64(player.sampleFreq)*float64(time.Second))))
+			break
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(messages, "Rendered %s to %s\n", formatTime(time.Duration(float64(rendered)/float64(player.sampleFreq)*float64(time.Second))), r.OutputFile)
+	return nil
+}
+
+// tuneComments returns the Vorbis comments of a render from the tune header
+// and the subtune, counted from 1 as track numbers are.
+func tuneComments(player *SidPlayer) []string {
+	num, songs := player.Tune()
+	comments := []string{
+		"TITLE=" + psid.Text(player.songHeader.Name),
+		"ARTIST=" + psid.Text(player.songHeader.Author),
+	}
+
+	// The released field is the year followed by the publisher.
+	released := psid.Text(player.songHeader.Released)
+	if year, _, _ := strings.Cut(released, " "); len(year) == 4 {
+		comments = append(comments, "DATE="+year)
+	}
+	comments = append(comments,
+		"COPYRIGHT="+released,
+		"TRACKNUMBER="+strconv.Itoa(num+1),
+		"TRACKTOTAL="+strconv.Itoa(songs),
+	)
+	return comments
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestSilenceDetector(t *testing.T) {
+	loud := func(n int) []int16 {
+		s := make([]int16, n)
+		for i := range s {
+			s[i] = int16(1000 * (i % 2))
+		}
+		return s
+	}
+	level := func(n int, v int16) []int16 {
+		s := make([]int16, n)
+		for i := range s {
+			s[i] = v
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		samples []int16
+		silent  int
+	}{
+		{"silence before the tune is heard", level(100, 0), 0},
+		{"sound", loud(100), 1},
+		{"silence after sound", append(loud(10), level(100, 0)...), 100},
+		{"DC offset", append(loud(10), level(100, -500)...), 100},
+		{"noise within the level", append(loud(10), append(level(50, 8), level(50, 8-SILENCE_LEVEL)...)...), 100},
+		{"noise above the level", append(loud(10), append(level(50, 8), level(50, 7-SILENCE_LEVEL)...)...), 50},
+		{"silence again", append(append(loud(10), level(30, 0)...), append(loud(10), level(20, 0)...)...), 20},
+	}
+
+	for _, test := range tests {
+		var d silenceDetector
+		silent := 0
+		for _, v := range test.samples {
+			silent = d.add(v)
+		}
+		if silent != test.silent {
+			t.Errorf("%s: %d silent samples, want %d", test.name, silent, test.silent)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// rewindBuffer is a ring of machine snapshots taken at a fixed interval of
+// playing time. Memory use is bounded by the number of snapshots; each one
+// holds the 64K of RAM plus the SID state.
+type rewindBuffer struct {
+	// Snapshots, oldest first starting at start, and the playing time
+	// between them.
+	entries  []rewindEntry
+	start    int
+	count    int
+	interval time.Duration
+}
+
+type rewindEntry struct {
+	elapsedCycles uint64
+	data          bytes.Buffer
+}
+
+func newRewindBuffer(count int, interval time.Duration) *rewindBuffer {
+	return &rewindBuffer{entries: make([]rewindEntry, count), interval: interval}
+}
+
+func (r *rewindBuffer) clear() {
+	r.start = 0
+	r.count = 0
+}
+
+// entry returns the i-th snapshot, 0 being the oldest.
+func (r *rewindBuffer) entry(i int) *rewindEntry {
+	return &r.entries[(r.start+i)%len(r.entries)]
+}
+
+// due tells whether a snapshot is to be taken at the given time, with the
+// interval in cycles.
+func (r *rewindBuffer) due(elapsedCycles uint64, interval uint64) bool {
+	return r.count == 0 || elapsedCycles >= r.entry(r.count-1).elapsedCycles+interval
+}
+
+// add returns the entry to store the next snapshot in, replacing the oldest
+// one when the ring is full.
+func (r *rewindBuffer) add() *rewindEntry {
+	if r.count == len(r.entries) {
+		r.start = (r.start + 1) % len(r.entries)
+		r.count--
+	}
+	r.count++
+	e := r.entry(r.count - 1)
+	e.data.Reset()
+	return e
+}
+
+// find returns the index of the latest snapshot at or before the given
+// time, -1 if there is none.
+func (r *rewindBuffer) find(elapsedCycles uint64) int {
+	for i := r.count - 1; i >= 0; i-- {
+		if r.entry(i).elapsedCycles <= elapsedCycles {
+			return i
+		}
+	}
+	return -1
+}
+
+// setRewind keeps count snapshots taken every interval of playing time, or
+// turns rewinding off for a count of 0.
+func (s *SidPlayer) setRewind(count int, interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if count <= 0 {
+		s.rewind = nil
+		return
+	}
+	s.rewind = newRewindBuffer(count, interval)
+}
+
+// recordRewind takes a snapshot when one is due. It is called at frame
+// boundaries, where no SID writes are pending. The snapshot is written in
+// the render path with the player locked, about 70-140 KB every interval,
+// which is why rewinding is off unless asked for.
+func (s *SidPlayer) recordRewind() {
+	if s.rewind == nil || !s.rewind.due(s.elapsedCycles, s.durationCycles(s.rewind.interval)) {
+		return
+	}
+
+	e := s.rewind.add()
+	e.elapsedCycles = s.elapsedCycles
+	if err := s.saveState(&e.data); err != nil {
+		fmt.Fprintf(messages, "Warning: rewind turned off (%v)\n", err)
+		s.rewind = nil
+	}
+}
+
+// Rewind steps playback back by d.
+func (s *SidPlayer) Rewind(d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	back := s.durationCycles(d)
+	if back > s.elapsedCycles {
+		back = s.elapsedCycles
+	}
+	return s.rewindTo(s.elapsedCycles - back)
+}
+
+// Scrub moves playback to a point in time that has been played, within the
+// reach of the rewind snapshots.
+func (s *SidPlayer) Scrub(t time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	target := s.durationCycles(t)
+	if target > s.elapsedCycles {
+		return errors.New("not played that far yet")
+	}
+	return s.rewindTo(target)
+}
+
+// rewindTo restores the latest snapshot before the target time and replays
+// from there up to it. Later snapshots are dropped.
+func (s *SidPlayer) rewindTo(elapsedCycles uint64) error {
+	if s.rewind == nil {
+		return errors.New("rewind is off")
+	}
+
+	i := s.rewind.find(elapsedCycles)
+	if i < 0 {
+		return errors.New("no snapshot that far back")
+	}
+	e := s.rewind.entry(i)
+	if err := s.loadState(bytes.NewReader(e.data.Bytes())); err != nil {
+		return err
+	}
+	s.rewind.count = i + 1
+
+	s.skipTo(elapsedCycles)
+	return nil
+}
+
+// skipTo plays silently up to the given time, to the nearest sample.
+func (s *SidPlayer) skipTo(elapsedCycles uint64) {
+	stems := s.stems
+	s.stems = nil
+	defer func() { s.stems = stems }()
+
+	var buf [1024]int16
+	for s.elapsedCycles < elapsedCycles {
+		samples := (elapsedCycles - s.elapsedCycles) * uint64(s.sampleFreq) / uint64(s.clockFreq)
+		s.render(buf[:min(samples+1, uint64(len(buf)))])
+	}
+}
+
+// durationCycles converts playing time to cycles.
+func (s *SidPlayer) durationCycles(d time.Duration) uint64 {
+	return uint64(d.Seconds() * float64(s.clockFreq))
+}
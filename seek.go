@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"time"
+	resid "yaspg/app/sid"
+)
+
+// Seek moves playback to a point in time of the current subtune. The play
+// routine runs and the SID is clocked as usual, with the register writes
+// applied at their cycle, but no audio is generated. Seeking back starts
+// from the latest rewind snapshot before the target, or from the start of
+// the subtune.
+func (s *SidPlayer) Seek(d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isPlaying {
+		return errors.New("not playing")
+	}
+
+	target := s.durationCycles(d)
+	if target < s.elapsedCycles {
+		if err := s.seekBack(target); err != nil {
+			return err
+		}
+	}
+	s.seekTo(target)
+	return nil
+}
+
+// seekBack goes back to the latest point before the target time to seek on
+// from.
+func (s *SidPlayer) seekBack(elapsedCycles uint64) error {
+	if s.rewind != nil {
+		if i := s.rewind.find(elapsedCycles); i >= 0 {
+			if err := s.loadState(bytes.NewReader(s.rewind.entry(i).data.Bytes())); err != nil {
+				return err
+			}
+			s.rewind.count = i + 1
+			return nil
+		}
+	}
+
+	s.Start()
+	return nil
+}
+
+// seekTo clocks the machine up to the given time without generating audio.
+func (s *SidPlayer) seekTo(elapsedCycles uint64) {
+	for s.elapsedCycles < elapsedCycles {
+		if s.frameCycles <= 0 {
+			s.startFrame()
+		}
+
+		delta_t := min(s.nextClock(), resid.CycleCount(elapsedCycles-s.elapsedCycles))
+		s.sid.Clock(delta_t)
+		s.advance(delta_t)
+	}
+}
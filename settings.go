@@ -1,12 +1,54 @@
 package main
 
-import "flag"
+import (
+	"flag"
+	"fmt"
+	"strings"
+	resid "yaspg/app/sid"
+)
 
 type SidPlayerSettings struct {
-	Subtune    int
-	Samplefreq int
-	SidModel   int
-	Usage      int
+	Subtune           int
+	Samplefreq        int
+	SidModel          int
+	PreferredSidModel int
+	VideoStandard     int
+	SamplingMethod    int
+	DACModel          string
+	FilterType        int
+	FilterCurve       string
+	WaveTables        string
+	DigiBoost         bool
+	PaddleScript      string
+	InputFile         string
+	InputGain         float64
+	OutputFilter      string
+	OutputHighPass    float64
+	OutputLowPass     float64
+	Clip              string
+	Stems             string
+	StemFiles         bool
+	StemTap           string
+	Snapshot          string
+	Rewind            int
+	RewindInterval    float64
+	Seek              string
+	Buffer            int
+	Latency           int
+	Output            string
+	Usage             int
+}
+
+// RenderSettings are the options of the render command, on top of the
+// player settings.
+type RenderSettings struct {
+	OutputFile  string
+	Time        string
+	Subtune     int
+	Stereo      bool
+	Float       bool
+	SongLengths string
+	Silence     float64
 }
 
 func NewSidPlayerSettings() *SidPlayerSettings {
@@ -14,9 +56,60 @@ func NewSidPlayerSettings() *SidPlayerSettings {
 	return opt
 }
 
-func (opt *SidPlayerSettings) ParseArgs() {
+func NewRenderSettings() *RenderSettings {
+	return &RenderSettings{}
+}
+
+// ParseArgs parses the command line arguments following the program name,
+// or the command.
+func (opt *SidPlayerSettings) ParseArgs(args []string) error {
 	flag.IntVar(&opt.Subtune, "a", -1, "Accumulator value on init (subtune number) default -1")
 	flag.IntVar(&opt.Samplefreq, "s", 22050, "Playback audio frequency in Hz, default 22050.")
-	flag.IntVar(&opt.SidModel, "m", 0, "Sid model to use, 0=6581, 1=8580, default 0")
-	flag.Parse()
+	flag.IntVar(&opt.SidModel, "m", -1, "Force Sid model, -1=from tune header, 0=6581, 1=8580, default -1")
+	flag.IntVar(&opt.PreferredSidModel, "pm", 0, "Sid model for tunes that play on either or unknown model, 0=6581, 1=8580, default 0")
+	flag.IntVar(&opt.VideoStandard, "c", -1, "Video standard, -1=from tune header, 0=PAL, 1=NTSC, 2=old NTSC, 3=Drean, default -1")
+	flag.IntVar(&opt.SamplingMethod, "sm", 0, "Sampling method, 0=fast, 1=interpolate, 2=resample interpolate, 3=resample fast, default 0")
+	flag.StringVar(&opt.DACModel, "dac", "ideal", "DAC model, ideal, 6581, 8580 or chip to follow the Sid model, default ideal")
+	flag.IntVar(&opt.FilterType, "f", 0, "Filter model, 0=linear, 1=non-linear (op-amp/VCR), default 0")
+	flag.StringVar(&opt.FilterCurve, "fc", "", "Filter cutoff curve, a preset ("+strings.Join(resid.FilterCurveNames(), ", ")+") or a text/JSON curve file, default from Sid model")
+	flag.StringVar(&opt.WaveTables, "wt", "", "Combined waveform tables file, binary (16384 bytes) or text, default from Sid model")
+	flag.BoolVar(&opt.DigiBoost, "db", false, "Digi boost, feed EXT IN on 8580 to make volume register samples audible, default false")
+	flag.StringVar(&opt.PaddleScript, "paddles", "", "Paddle script file with time, POTX and POTY on each line, default none")
+	flag.StringVar(&opt.InputFile, "in", "", "WAV file to feed into the EXT IN pin, default none")
+	flag.Float64Var(&opt.InputGain, "ingain", 1.0, "Gain of the EXT IN recording, default 1.0")
+	flag.StringVar(&opt.OutputFilter, "of", "c64", "Output filter of the C64 board, c64 or none, default c64")
+	flag.Float64Var(&opt.OutputHighPass, "ofhp", -1, "Output high-pass corner frequency in Hz, 0=off, -1=from output filter, default -1")
+	flag.Float64Var(&opt.OutputLowPass, "oflp", -1, "Output low-pass corner frequency in Hz, 0=off, -1=from output filter, default -1")
+	flag.StringVar(&opt.Clip, "clip", "hard", "Clipping of the output to 16 bits, hard or soft, default hard")
+	flag.StringVar(&opt.Stems, "stems", "", "WAV file to record the mix and the three voices to, as 4 channels, default none")
+	flag.BoolVar(&opt.StemFiles, "stemfiles", false, "Record the stems to separate files, <name>_mix.wav, <name>_voice1.wav..., default false")
+	flag.StringVar(&opt.StemTap, "stemtap", "mix", "Voice stems, mix for the contribution to the output or voice for the output before the filter, default mix")
+	flag.StringVar(&opt.Snapshot, "snapshot", "", "Machine snapshot to continue from, taken with the same tune and options, default none")
+	flag.IntVar(&opt.Rewind, "rewind", 0, "Number of snapshots kept for rewinding, about 70-140 KB each and taken while rendering audio, 0=off, default 0")
+	flag.Float64Var(&opt.RewindInterval, "rewindint", 2, "Playing time between rewind snapshots in seconds, default 2")
+	flag.StringVar(&opt.Seek, "seek", "", "Start playing at a time, as seconds or m:ss, default from the start")
+	flag.IntVar(&opt.Buffer, "buffer", 1024, "Audio device buffer in samples, 1-32768, default 1024")
+	flag.IntVar(&opt.Latency, "latency", 100, "Audio buffered ahead of the device in milliseconds, default 100")
+	flag.StringVar(&opt.Output, "out", "sdl", "Audio output, sdl, null, raw for 16-bit samples on stdout or wav:<file>, default sdl")
+	flag.CommandLine.Parse(args)
+
+	if opt.Buffer < 1 || opt.Buffer > 32768 {
+		return fmt.Errorf("audio buffer of %d samples, expected 1 to 32768", opt.Buffer)
+	}
+	if opt.Latency < 0 {
+		return fmt.Errorf("negative latency of %d ms", opt.Latency)
+	}
+	return nil
+}
+
+// AddFlags adds the render options, to be parsed along with the player
+// settings.
+func (r *RenderSettings) AddFlags() {
+	flag.StringVar(&r.OutputFile, "o", "", "WAV or FLAC file to render to, by the extension")
+	flag.StringVar(&r.Time, "t", "", "Time to render, as seconds or m:ss, default from the Songlengths file or 3:00")
+	flag.IntVar(&r.Subtune, "subtune", -1, "Subtune to render, counting from 0, -1=from the -a option or the tune header, default -1")
+	flag.BoolVar(&r.Stereo, "stereo", false, "Render the output to both channels of a stereo file, default false")
+	flag.BoolVar(&r.Float, "float", false, "Render 32-bit float samples instead of 16-bit, WAV only, the same 16-bit output without extra precision, default false")
+	flag.StringVar(&r.SongLengths, "songlengths", "", "HVSC Songlengths.md5 file with the playing time of the tune, default none")
+	flag.Float64Var(&r.Silence, "silence", 5, "Stop after this many seconds of silence, 0=off, default 5")
 }
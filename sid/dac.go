@@ -0,0 +1,110 @@
+package resid
+
+import "math"
+
+// DacModel describes the R-2R resistor ladder of the D/A converters in the
+// SID chip.
+//
+// In the MOS6581 the 2R resistors are slightly too large and the ladders
+// lack the terminating 2R resistor at the least significant bit. The bits
+// are then not weighted by exact powers of two; e.g. an 8-bit input of 0x80
+// yields a lower output than 0x7f. The MOS8580 ladders are close to ideal.
+type DacModel struct {
+	Name string
+
+	// Ratio 2R/R of the ladder resistors, 2.0 for a perfect ladder.
+	TwoRDivR float64
+
+	// Whether the ladder is terminated with a 2R resistor.
+	Term bool
+}
+
+// Built-in DAC models. DAC_IDEAL is linear, matching the original behavior.
+var (
+	DAC_IDEAL = DacModel{"ideal", 2.00, true}
+	DAC_6581  = DacModel{"6581", 2.20, false}
+	DAC_8580  = DacModel{"8580", 2.00, true}
+)
+
+// DacModelByName returns the built-in DAC model with the given name.
+func DacModelByName(name string) (DacModel, bool) {
+	for _, m := range []DacModel{DAC_IDEAL, DAC_6581, DAC_8580} {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return DacModel{}, false
+}
+
+// ----------------------------------------------------------------------------
+// Calculate the output of a bits wide R-2R ladder DAC for all inputs.
+//
+// The voltage contributed by each bit is found by repeated source
+// transformation through the ladder, and the output for any combination of
+// bits by superposition. The result is scaled so that the maximum output is
+// 2^bits - 1; for an ideal ladder each input thus maps onto itself.
+// ----------------------------------------------------------------------------
+func buildDacTable(bits int, model DacModel) []uint16 {
+	vbit := make([]float64, bits)
+
+	for set_bit := 0; set_bit < bits; set_bit++ {
+		Vn := 1.0 // Normalized bit voltage.
+		R := 1.0  // Normalized R.
+		_2R := model.TwoRDivR * R
+
+		// Rn = 2R for correct termination, infinity for missing termination.
+		Rn := math.Inf(1)
+		if model.Term {
+			Rn = _2R
+		}
+
+		// Calculate DAC "tail" resistance by repeated parallel substitution.
+		bit := 0
+		for ; bit < set_bit; bit++ {
+			if math.IsInf(Rn, 1) {
+				Rn = R + _2R
+			} else {
+				Rn = R + _2R*Rn/(_2R+Rn) // R + 2R || Rn
+			}
+		}
+
+		// Source transformation for bit voltage.
+		if math.IsInf(Rn, 1) {
+			Rn = _2R
+		} else {
+			Rn = _2R * Rn / (_2R + Rn) // 2R || Rn
+			Vn = Vn * Rn / _2R
+		}
+
+		// Calculate DAC output voltage by repeated source transformation
+		// from the "tail".
+		for bit++; bit < bits; bit++ {
+			Rn += R
+			I := Vn / Rn
+			Rn = _2R * Rn / (_2R + Rn) // 2R || Rn
+			Vn = Rn * I
+		}
+
+		vbit[set_bit] = Vn
+	}
+
+	// Calculate the voltage for any combination of bits by superpositioning,
+	// and scale the maximum output to 2^bits - 1.
+	vmax := 0.0
+	for _, v := range vbit {
+		vmax += v
+	}
+
+	dac := make([]uint16, 1<<bits)
+	for i := range dac {
+		Vo := 0.0
+		for j := 0; j < bits; j++ {
+			if i&(1<<j) != 0 {
+				Vo += vbit[j]
+			}
+		}
+		dac[i] = uint16(float64(len(dac)-1)*Vo/vmax + 0.5)
+	}
+
+	return dac
+}
@@ -22,6 +22,16 @@ type EnvelopeGenerator struct {
 	release                    reg4
 	gate                       reg8
 	state                      State
+
+	// Pipeline delays in cycles, as in the reSID 1.0 derived envelope of
+	// libsidplayfp: a state change from a gate bit write, an envelope
+	// counter step, and the exponential counter comparison. The rate
+	// counter is zeroed in the cycle after it reaches the comparison value.
+	nextState           State
+	statePipeline       int
+	envelopePipeline    int
+	exponentialPipeline int
+	resetRateCounter    bool
 }
 
 // ----------------------------------------------------------------------------
@@ -46,6 +56,11 @@ func (e *EnvelopeGenerator) Reset() {
 	e.state = RELEASE
 	e.rate_period = rate_counter_period[e.release]
 	e.holdZero = true
+	e.nextState = RELEASE
+	e.statePipeline = 0
+	e.envelopePipeline = 0
+	e.exponentialPipeline = 0
+	e.resetRateCounter = false
 }
 
 // ----------------------------------------------------------------------------
@@ -53,47 +68,53 @@ func (e *EnvelopeGenerator) Reset() {
 // ----------------------------------------------------------------------------
 
 func (e *EnvelopeGenerator) Clock(delta_t CycleCount) {
-	// Check for ADSR delay bug.
-	// If the rate counter comparison value is set below the current value of the
-	// rate counter, the counter will continue counting up until it wraps around
-	// to zero at 2^15 = 0x8000, and then count rate_period - 1 before the
-	// envelope can finally be stepped.
-	// This has been verified by sampling ENV3.
-	//
-
-	// NB! This requires two's complement integer.
-	rate_step := int(e.rate_period) - int(e.rate_counter)
-	if rate_step <= 0 {
-		rate_step += 0x7fff
-	}
-
 	for delta_t > 0 {
-		if delta_t < CycleCount(rate_step) {
-			e.rate_counter += reg16(delta_t)
-			if e.rate_counter&0x8000 != 0 {
-				e.rate_counter++
-				e.rate_counter &= 0x7fff
+		// Outside the pipelines only the rate counter moves, up to the
+		// cycle in which it reaches the comparison value.
+		if e.statePipeline == 0 && e.envelopePipeline == 0 &&
+			e.exponentialPipeline == 0 && !e.resetRateCounter {
+			if n := min(delta_t, e.rateSteps()); n > 0 {
+				e.rate_counter += reg16(n)
+				if e.rate_counter&0x8000 != 0 {
+					e.rate_counter -= 0x7fff
+				}
+				delta_t -= n
+				continue
 			}
-			return
 		}
 
-		e.rate_counter = 0
-		delta_t -= CycleCount(rate_step)
-
-		// The first envelope step in the attack state also resets the exponential
-		// counter. This has been verified by sampling ENV3.
-		//
-		e.exponential_counter++
-		if e.state == ATTACK ||
-			e.exponential_counter == e.exponential_counter_period {
-			e.exponential_counter = 0
+		e.clock()
+		delta_t--
+	}
+}
 
-			// Check whether the envelope counter is frozen at zero.
-			if e.holdZero {
-				rate_step = int(e.rate_period)
-				continue
-			}
+// Number of cycles before the rate counter reaches the comparison value.
+//
+// Check for ADSR delay bug.
+// If the rate counter comparison value is set below the current value of the
+// rate counter, the counter will continue counting up until it wraps around
+// to zero at 2^15 = 0x8000, and then count rate_period - 1 before the
+// envelope can finally be stepped.
+// This has been verified by sampling ENV3.
+func (e *EnvelopeGenerator) rateSteps() CycleCount {
+	compare := CycleCount(e.rate_period) - 1
+	counter := CycleCount(e.rate_counter)
+	if counter > compare {
+		return 0x7fff - counter + compare
+	}
+	return compare - counter
+}
+
+// Clock a single cycle.
+func (e *EnvelopeGenerator) clock() {
+	if e.statePipeline > 0 {
+		e.stateChange()
+	}
 
+	// The envelope counter is stepped at the end of the pipeline.
+	if e.envelopePipeline > 0 {
+		e.envelopePipeline--
+		if e.envelopePipeline == 0 && !e.holdZero {
 			switch e.state {
 			case ATTACK:
 				// The envelope counter can flip from 0xff to 0x00 by changing state to
@@ -107,11 +128,7 @@ func (e *EnvelopeGenerator) Clock(delta_t CycleCount) {
 					e.state = DECAY_SUSTAIN
 					e.rate_period = rate_counter_period[e.decay]
 				}
-			case DECAY_SUSTAIN:
-				if e.envelope_counter != int(sustain_level[e.sustain]) {
-					e.envelope_counter--
-				}
-			case RELEASE:
+			case DECAY_SUSTAIN, RELEASE:
 				// The envelope counter can flip from 0x00 to 0xff by changing state to
 				// attack, then to release. The envelope counter will then continue
 				// counting down in the release state.
@@ -121,30 +138,102 @@ func (e *EnvelopeGenerator) Clock(delta_t CycleCount) {
 				e.envelope_counter--
 				e.envelope_counter &= 0xff
 			}
+			e.setExponentialCounter()
+		}
+	}
 
-			// Check for change of exponential counter period.
-			switch e.envelope_counter {
-			case 0xff:
-				e.exponential_counter_period = 1
-			case 0x5d:
-				e.exponential_counter_period = 2
-			case 0x36:
-				e.exponential_counter_period = 4
-			case 0x1a:
-				e.exponential_counter_period = 8
-			case 0x0e:
-				e.exponential_counter_period = 16
-			case 0x06:
-				e.exponential_counter_period = 30
-			case 0x00:
-				e.exponential_counter_period = 1
-				// When the envelope counter is changed to zero, it is frozen at zero.
-				// This has been verified by sampling ENV3.
-				e.holdZero = true
+	if e.exponentialPipeline > 0 {
+		// The exponential counter matched its period; the decrement follows
+		// in the next cycle.
+		e.exponentialPipeline--
+		if e.exponentialPipeline == 0 {
+			e.exponential_counter = 0
+			if (e.state == DECAY_SUSTAIN && e.envelope_counter != int(sustain_level[e.sustain])) ||
+				e.state == RELEASE {
+				e.envelopePipeline = 1
+			}
+		}
+	} else if e.resetRateCounter {
+		e.rate_counter = 0
+		e.resetRateCounter = false
+
+		if e.state == ATTACK {
+			// The first envelope step in the attack state also resets the exponential
+			// counter. This has been verified by sampling ENV3.
+			//
+			e.exponential_counter = 0
+			e.envelopePipeline = 2
+		} else if !e.holdZero {
+			// The comparison with the exponential counter takes one more
+			// cycle when its period is not 1. This delays the decrement,
+			// but not the rate counter.
+			e.exponential_counter++
+			if e.exponential_counter == e.exponential_counter_period {
+				if e.exponential_counter_period != 1 {
+					e.exponentialPipeline = 2
+				} else {
+					e.exponentialPipeline = 1
+				}
 			}
 		}
+	}
+
+	// The rate counter is zeroed in the cycle after it reaches the
+	// comparison value, so the comparison value is one less than the
+	// period.
+	if e.rate_counter != e.rate_period-1 {
+		e.rate_counter++
+		if e.rate_counter&0x8000 != 0 {
+			e.rate_counter++
+			e.rate_counter &= 0x7fff
+		}
+	} else {
+		e.resetRateCounter = true
+	}
+}
+
+// Step the state pipeline of a gate bit write.
+func (e *EnvelopeGenerator) stateChange() {
+	e.statePipeline--
+
+	switch e.nextState {
+	case ATTACK:
+		// The attack rate takes over in the second cycle of the attack
+		// state, which also unlocks the zero freeze.
+		if e.statePipeline == 0 {
+			e.state = ATTACK
+			e.rate_period = rate_counter_period[e.attack]
+			e.holdZero = false
+		}
+	case RELEASE:
+		if (e.state == ATTACK && e.statePipeline == 0) ||
+			(e.state == DECAY_SUSTAIN && e.statePipeline == 1) {
+			e.state = RELEASE
+			e.rate_period = rate_counter_period[e.release]
+		}
+	}
+}
 
-		rate_step = int(e.rate_period)
+// Check for change of exponential counter period.
+func (e *EnvelopeGenerator) setExponentialCounter() {
+	switch e.envelope_counter {
+	case 0xff:
+		e.exponential_counter_period = 1
+	case 0x5d:
+		e.exponential_counter_period = 2
+	case 0x36:
+		e.exponential_counter_period = 4
+	case 0x1a:
+		e.exponential_counter_period = 8
+	case 0x0e:
+		e.exponential_counter_period = 16
+	case 0x06:
+		e.exponential_counter_period = 30
+	case 0x00:
+		e.exponential_counter_period = 1
+		// When the envelope counter is changed to zero, it is frozen at zero.
+		// This has been verified by sampling ENV3.
+		e.holdZero = true
 	}
 }
 
@@ -249,7 +338,7 @@ var rate_counter_period = [...]reg16{
 // (255 + 162*1 + 39*2 + 28*4 + 12*8 + 8*16 + 6*30)*32 = 756*32 = 32352
 // which corresponds exactly to the timed value divided by the number of
 // complete envelopes.
-// NB! This one cycle delay is not modeled.
+// The delay is modeled by the exponential counter pipeline, see clock.
 
 // From the sustain levels it follows that both the low and high 4 bits of the
 // envelope counter are compared to the 4-bit sustain value.
@@ -281,23 +370,41 @@ func (e *EnvelopeGenerator) WriteCONTROL_REG(control reg8) {
 
 	// The rate counter is never reset, thus there will be a delay before the
 	// envelope counter starts counting up (attack) or down (release).
+	// The new state takes effect through the state pipeline, see clock.
+	if e.gate == gate_next {
+		return
+	}
+	e.gate = gate_next
 
 	// Gate bit on: Start attack, decay, sustain.
-	if (e.gate == 0) && (gate_next != 0) {
-		e.state = ATTACK
-		e.rate_period = rate_counter_period[e.attack]
+	if gate_next != 0 {
+		// The decay rate is selected in the first cycle of the attack.
+		e.nextState = ATTACK
+		e.state = DECAY_SUSTAIN
+		e.rate_period = rate_counter_period[e.decay]
+		e.statePipeline = 2
 
-		// Switching to attack state unlocks the zero freeze.
-		e.holdZero = false
-	} else {
-		// Gate bit off: Start release.
-		if e.gate != 0 && gate_next == 0 {
-			e.state = RELEASE
-			e.rate_period = rate_counter_period[e.release]
+		// A pending envelope step becomes an attack step, taking the
+		// length of the pipeline it is in.
+		if e.resetRateCounter || e.exponentialPipeline == 2 {
+			if e.exponential_counter_period == 1 || e.exponentialPipeline == 2 {
+				e.envelopePipeline = 2
+			} else {
+				e.envelopePipeline = 4
+			}
+		} else if e.exponentialPipeline == 1 {
+			e.statePipeline = 3
 		}
+		return
 	}
 
-	e.gate = gate_next
+	// Gate bit off: Start release.
+	e.nextState = RELEASE
+	if e.envelopePipeline > 0 {
+		e.statePipeline = 3
+	} else {
+		e.statePipeline = 2
+	}
 }
 
 func (e *EnvelopeGenerator) WriteATTACK_DECAY(attack_decay reg8) {
@@ -0,0 +1,297 @@
+package resid
+
+import "testing"
+
+// The expected values below are the ENV3 and CIA timer measurements on real
+// chips that reSID documents, see the notes at rate_counter_period and
+// sustain_level, and not values taken from this implementation.
+
+// Clock the envelope one cycle at a time until the output changes, and
+// return the number of cycles it took.
+func cyclesToChange(e *EnvelopeGenerator, limit int) int {
+	prev := e.Output()
+	for c := 1; c <= limit; c++ {
+		e.Clock(1)
+		if e.Output() != prev {
+			return c
+		}
+	}
+	return -1
+}
+
+// Clock the envelope one cycle at a time until the output is level, and
+// return the number of cycles it took.
+func cyclesToLevel(e *EnvelopeGenerator, level reg8, limit int) int {
+	for c := 1; c <= limit; c++ {
+		e.Clock(1)
+		if e.Output() == level {
+			return c
+		}
+	}
+	return -1
+}
+
+// The rate periods measured by counting the cycles from envelope level 1 to
+// envelope level 129 and dividing by 128, with sustain = release = 0.
+func TestEnvelopeRatePeriods(t *testing.T) {
+	measured := []int{
+		9, 32, 63, 95, 149, 220, 267, 313,
+		392, 977, 1954, 3126, 3907, 11720, 19532, 31251,
+	}
+	for attack, period := range measured {
+		e := NewEnvelopeGenerator()
+		e.WriteATTACK_DECAY(reg8(attack << 4))
+		e.WriteSUSTAIN_RELEASE(0x00)
+		e.WriteCONTROL_REG(0x01)
+
+		if cyclesToLevel(e, 1, 2*period+2) < 0 {
+			t.Fatalf("attack %d: envelope level 1 not reached", attack)
+		}
+		if c := cyclesToLevel(e, 129, 129*period); c != 128*period {
+			t.Errorf("attack %d: %d cycles from level 1 to 129, want 128 * %d", attack, c, period)
+		}
+	}
+}
+
+// A complete envelope with A = D = R = 1, S = 0 takes
+// (255 + 162*1 + 39*2 + 28*4 + 12*8 + 8*16 + 6*30)*32 = 756*32 = 32352
+// cycles, timed over consecutive envelopes restarted as soon as ENV3 is
+// back at zero. The exponential counter delays the decrements, but not the
+// rate counter.
+func TestEnvelopeCompleteEnvelopes(t *testing.T) {
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0x11)
+	e.WriteSUSTAIN_RELEASE(0x01)
+	e.WriteCONTROL_REG(0x01)
+
+	var starts []int
+	prev := e.Output()
+	for cycle := 1; len(starts) < 5; cycle++ {
+		if cycle > 6*32352 {
+			t.Fatal("envelopes do not complete")
+		}
+		e.Clock(1)
+		level := e.Output()
+		switch {
+		case prev == 0 && level == 1:
+			starts = append(starts, cycle)
+		case prev != 0 && level == 0:
+			// Restart, as a CPU polling ENV3 would.
+			e.WriteCONTROL_REG(0x00)
+			e.Clock(4)
+			e.WriteCONTROL_REG(0x01)
+			cycle += 4
+		}
+		prev = level
+	}
+
+	for i := 1; i < len(starts); i++ {
+		if d := starts[i] - starts[i-1]; d != 32352 {
+			t.Errorf("envelope %d: %d cycles, want 32352", i, d)
+		}
+	}
+}
+
+// At the first period when an exponential counter period larger than one is
+// used one extra cycle is spent before the envelope is decremented. The rate
+// counter is not affected, so only the first step is late.
+func TestEnvelopeDelayedDecrement(t *testing.T) {
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0x00)
+	e.WriteSUSTAIN_RELEASE(0x00)
+	e.WriteCONTROL_REG(0x01)
+	cyclesToLevel(e, 1, 20)
+
+	// Attack and decay with A = D = 0 step every 9 cycles, until the
+	// exponential counter period changes at 0x5d.
+	for level := 2; level <= 0xff; level++ {
+		if c := cyclesToChange(e, 100); c != 9 {
+			t.Fatalf("attack to %#02x: %d cycles, want 9", level, c)
+		}
+	}
+	for level := 0xfe; level >= 0x5d; level-- {
+		if c := cyclesToChange(e, 100); c != 9 {
+			t.Fatalf("decay to %#02x: %d cycles, want 9", level, c)
+		}
+	}
+
+	want := []int{19, 18, 18, 18}
+	for i, w := range want {
+		if c := cyclesToChange(e, 100); c != w {
+			t.Fatalf("decay step %d below 0x5d: %d cycles, want %d", i, c, w)
+		}
+	}
+	if got := e.Output(); got != 0x59 {
+		t.Fatalf("envelope = %#02x, want 0x59", got)
+	}
+}
+
+// The delayed envelope output of decay or release is normalized when the
+// state is changed to attack: one cycle less is spent before the envelope is
+// incremented.
+func TestEnvelopeDelayedDecrementBeforeAttack(t *testing.T) {
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0x00)
+	e.WriteSUSTAIN_RELEASE(0x00)
+	e.WriteCONTROL_REG(0x01)
+	cyclesToLevel(e, 0x50, 10000)
+
+	e.WriteCONTROL_REG(0x00)
+	cyclesToChange(e, 100)
+	e.WriteCONTROL_REG(0x01)
+	if c := cyclesToChange(e, 100); c != 8 {
+		t.Fatalf("attack after delayed release step: %d cycles, want 8", c)
+	}
+	if got := e.Output(); got != 0x50 {
+		t.Fatalf("envelope = %#02x, want 0x50", got)
+	}
+	if c := cyclesToChange(e, 100); c != 9 {
+		t.Fatalf("next attack step: %d cycles, want 9", c)
+	}
+}
+
+// If the rate counter comparison value is set below the current value of
+// the rate counter, the counter continues counting up until it wraps around
+// to zero at 2^15 = 0x8000, and then counts rate_period - 1 before the
+// envelope is stepped.
+func TestEnvelopeADSRDelayBug(t *testing.T) {
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0xf0)
+	e.WriteSUSTAIN_RELEASE(0x00)
+	e.WriteCONTROL_REG(0x01)
+	cyclesToLevel(e, 1, 2*31251)
+
+	e.Clock(1000)
+	e.WriteATTACK_DECAY(0x00)
+	if c := 1000 + cyclesToChange(e, 0x10000); c != 0x8000+9-1 {
+		t.Fatalf("step after lowering the rate period: %d cycles, want %d", c, 0x8000+9-1)
+	}
+	if c := cyclesToChange(e, 100); c != 9 {
+		t.Fatalf("next step: %d cycles, want 9", c)
+	}
+}
+
+// Both the low and high 4 bits of the envelope counter are compared to the
+// sustain value.
+func TestEnvelopeSustainLevel(t *testing.T) {
+	for sustain := 0; sustain < 16; sustain++ {
+		e := NewEnvelopeGenerator()
+		e.WriteATTACK_DECAY(0x00)
+		e.WriteSUSTAIN_RELEASE(reg8(sustain << 4))
+		e.WriteCONTROL_REG(0x01)
+		e.Clock(0x10000)
+		if got, want := e.Output(), reg8(sustain*0x11); got != want {
+			t.Errorf("sustain %#x: envelope = %#02x, want %#02x", sustain, got, want)
+		}
+	}
+}
+
+// The envelope counter can flip from 0xff to 0x00 by changing state to
+// release, then to attack. It is then frozen at zero, until the state is
+// changed to release, then to attack.
+func TestEnvelopeFlipToZero(t *testing.T) {
+	// Slow decay and release rates, so that the envelope does not step in
+	// the release state.
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0x0f)
+	e.WriteSUSTAIN_RELEASE(0xff)
+	e.WriteCONTROL_REG(0x01)
+	e.Clock(0x1000)
+	if got := e.Output(); got != 0xff {
+		t.Fatalf("envelope at sustain = %#02x, want 0xff", got)
+	}
+
+	// The rate counter is above the attack period now; the first attack
+	// step waits for it to wrap around.
+	e.WriteCONTROL_REG(0x00)
+	e.Clock(2)
+	e.WriteCONTROL_REG(0x01)
+	e.Clock(0x10000)
+	if got := e.Output(); got != 0x00 {
+		t.Fatalf("envelope after release, attack = %#02x, want 0x00", got)
+	}
+
+	e.WriteCONTROL_REG(0x00)
+	e.Clock(2)
+	e.WriteCONTROL_REG(0x01)
+	if cyclesToLevel(e, 1, 100) < 0 {
+		t.Fatal("envelope still frozen at zero after release, attack")
+	}
+}
+
+// The envelope counter can flip from 0x00 to 0xff by changing state to
+// attack, then to release. It then continues counting down in the release
+// state.
+func TestEnvelopeFlipToMax(t *testing.T) {
+	e := NewEnvelopeGenerator()
+	e.WriteATTACK_DECAY(0xf0)
+	e.WriteSUSTAIN_RELEASE(0x00)
+	e.WriteCONTROL_REG(0x01)
+	e.Clock(3)
+	e.WriteCONTROL_REG(0x00)
+	if cyclesToLevel(e, 0xff, 100) < 0 {
+		t.Fatal("envelope did not flip to 0xff")
+	}
+	if cyclesToLevel(e, 0xfe, 100) < 0 {
+		t.Fatal("envelope did not continue counting down")
+	}
+}
+
+// Clocking in batches must give the same envelope as clocking one cycle at
+// a time, including the pipelined state changes and decrements.
+func TestEnvelopeClockBatches(t *testing.T) {
+	writes := []struct {
+		cycle int
+		reg   int
+		value reg8
+	}{
+		{0, 5, 0x11}, {0, 6, 0x82}, {0, 4, 0x01},
+		{4000, 4, 0x00},
+		{4321, 4, 0x01},
+		{9000, 5, 0x03}, {9001, 4, 0x00},
+		{20000, 6, 0x01}, {20007, 4, 0x01}, {20008, 4, 0x00},
+		{20009, 4, 0x01}, {40000, 4, 0x00},
+		{60000, 5, 0xf0}, {60001, 4, 0x01}, {61000, 5, 0x00},
+	}
+	const end = 120000
+
+	// Trace the output at every 100th cycle.
+	run := func(batch int) []reg8 {
+		e := NewEnvelopeGenerator()
+		var trace []reg8
+		next := 0
+		for cycle := 0; cycle < end; {
+			for ; next < len(writes) && writes[next].cycle == cycle; next++ {
+				switch w := writes[next]; w.reg {
+				case 4:
+					e.WriteCONTROL_REG(w.value)
+				case 5:
+					e.WriteATTACK_DECAY(w.value)
+				case 6:
+					e.WriteSUSTAIN_RELEASE(w.value)
+				}
+			}
+
+			n := min(batch, 100-cycle%100)
+			if next < len(writes) {
+				n = min(n, writes[next].cycle-cycle)
+			}
+			e.Clock(CycleCount(n))
+			cycle += n
+			if cycle%100 == 0 {
+				trace = append(trace, e.Output())
+			}
+		}
+		return trace
+	}
+
+	want := run(1)
+	for _, batch := range []int{3, 9, 100} {
+		got := run(batch)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("batch %d, cycle %d: envelope = %#02x, want %#02x", batch, (i+1)*100, got[i], want[i])
+			}
+		}
+	}
+}
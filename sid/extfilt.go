@@ -1,6 +1,7 @@
 package resid
 
-// Filter represents the filter in the SID chip.
+// ExternalFilter represents the RC filters on the C64 board between the SID
+// audio output and the audio output of the C64.
 type ExternalFilter struct {
 	mixer_DC sound_sample
 
@@ -10,9 +11,49 @@ type ExternalFilter struct {
 	// Cutoff frequencies.
 	w0lp, w0hp sound_sample
 
+	// Corner frequencies in Hz, 0 for no filter, and the pass frequency of
+	// the sampling which limits the low-pass corner.
+	hpFreq, lpFreq, passFreq float64
+
 	enabled bool
 }
 
+// ExternalFilterModel describes the output stage of a C64 board revision.
+// A corner frequency of 0 leaves out the filter.
+type ExternalFilterModel struct {
+	Name     string
+	HighPass float64
+	LowPass  float64
+}
+
+// Built-in output stages.
+//
+// EXTFILTER_C64 is the output stage of reSID, from the C64 schematics:
+//
+//	Low-pass:  R = 10kOhm, C = 1000pF; f = 1/(2*pi*RC) = 15915.5 Hz
+//	High-pass: R =  1kOhm, C =   10uF; f = 1/(2*pi*RC) =    15.9 Hz
+//
+// EXTFILTER_NONE bypasses the output stage altogether.
+//
+// The output stage differs between board revisions, but there are no
+// measured component values for the other boards at hand; their corner
+// frequencies can be set directly, see SetCornerFrequencies.
+var (
+	EXTFILTER_C64  = ExternalFilterModel{"c64", 15.9155, 15915.6}
+	EXTFILTER_NONE = ExternalFilterModel{"none", 0, 0}
+)
+
+// ExternalFilterModelByName returns the built-in output stage with the
+// given name.
+func ExternalFilterModelByName(name string) (ExternalFilterModel, bool) {
+	for _, m := range []ExternalFilterModel{EXTFILTER_C64, EXTFILTER_NONE} {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return ExternalFilterModel{}, false
+}
+
 // ----------------------------------------------------------------------------
 // Constructor.
 // ----------------------------------------------------------------------------
@@ -20,6 +61,7 @@ func NewExternalFilter() *ExternalFilter {
 	f := &ExternalFilter{}
   f.Reset()
   f.EnableFilter(true)
+  f.SetCornerFrequencies(EXTFILTER_C64.HighPass, EXTFILTER_C64.LowPass)
   f.SetSamplingParameter(15915.6)
   f.SetModel(MOS6581)
   // f.SetModel(MOS8580)
@@ -41,20 +83,38 @@ func (e *ExternalFilter) EnableFilter(enable bool) {
 // Setup of the external filter sampling parameters.
 // ----------------------------------------------------------------------------
 func (f *ExternalFilter) SetSamplingParameter(pass_freq float64) {
-//   static const float pi = 3.1415926535897932385;
-
-  // Low-pass:  R = 10kOhm, C = 1000pF; w0l = 1/RC = 1/(1e4*1e-9) = 100000
-  // High-pass: R =  1kOhm, C =   10uF; w0h = 1/RC = 1/(1e3*1e-5) =    100
-  // Multiply with 1.048576 to facilitate division by 1 000 000 by right-
-  // shifting 20 times (2 ^ 20 = 1048576).
-
-  f.w0hp = 105
-  f.w0lp = sound_sample(pass_freq * (2.0 * pi * 1.048576))
-  if (f.w0lp > 104858) {
-    f.w0lp = 104858;
-  }
+	f.passFreq = pass_freq
+	f.setW0()
 }
 
+// ----------------------------------------------------------------------------
+// Set corner frequencies in Hz. A frequency of 0 leaves out the filter.
+// ----------------------------------------------------------------------------
+func (f *ExternalFilter) SetCornerFrequencies(high_pass float64, low_pass float64) {
+	f.hpFreq = high_pass
+	f.lpFreq = low_pass
+	f.setW0()
+}
+
+func (f *ExternalFilter) setW0() {
+	//   static const float pi = 3.1415926535897932385;
+
+	// Multiply with 1.048576 to facilitate division by 1 000 000 by right-
+	// shifting 20 times (2 ^ 20 = 1048576).
+
+	f.w0hp = sound_sample(f.hpFreq*(2.0*pi*1.048576) + 0.5)
+
+	// The low-pass filter also attenuates frequencies above the pass
+	// frequency of the sampling.
+	if f.lpFreq == 0 {
+		f.w0lp = 0
+		return
+	}
+	f.w0lp = sound_sample(min(f.lpFreq, f.passFreq) * (2.0 * pi * 1.048576))
+	if f.w0lp > 104858 {
+		f.w0lp = 104858
+	}
+}
 
 // ----------------------------------------------------------------------------
 // Set chip model.
@@ -100,6 +160,10 @@ func (e *ExternalFilter) Clock(delta_t CycleCount, Vi sound_sample ) {
         // Vhp = Vhp + w0hp*(Vlp - Vhp)*delta_t;
 
         dVlp := sound_sample(e.w0lp*sound_sample(delta_t_flt) >> 8)*(Vi - e.Vlp) >> 12
+        if (e.w0lp == 0) {
+            // No low-pass filter.
+            dVlp = Vi - e.Vlp
+        }
         dVhp := sound_sample(e.w0hp*sound_sample(delta_t_flt)*(e.Vlp - e.Vhp) >> 20)
         e.Vo = e.Vlp - e.Vhp;
         e.Vlp += dVlp;
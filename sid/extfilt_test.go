@@ -0,0 +1,168 @@
+package resid
+
+import (
+	"math"
+	"testing"
+)
+
+// Samples below the knee at 3/4 of full scale pass unchanged; above it the
+// curve rises with a falling slope towards full scale, without reaching it.
+func TestSoftClip(t *testing.T) {
+	const half = 1 << 15
+	const knee = half * 3 / 4
+	for _, v := range []int{0, 1, -1, 1000, -1000, knee, -knee} {
+		if got := softClip(v, half); got != v {
+			t.Errorf("softClip(%d) = %d, want unchanged", v, got)
+		}
+	}
+
+	prev := softClip(knee, half)
+	prevStep := 64
+	for v := knee + 64; v < 4*half; v += 64 {
+		got := softClip(v, half)
+		if got < prev || got-prev > 64 {
+			t.Fatalf("softClip(%d) = %d after %d, want a slope from 0 to 1", v, got, prev)
+		}
+		if step := got - prev; step > prevStep+1 {
+			t.Fatalf("softClip(%d): slope rises above the knee", v)
+		} else {
+			prevStep = step
+		}
+		if got >= half {
+			t.Fatalf("softClip(%d) = %d, want below %d", v, got, half)
+		}
+		if neg := softClip(-v, half); neg != -got {
+			t.Fatalf("softClip(%d) = %d, want %d", -v, neg, -got)
+		}
+		prev = got
+	}
+	if got := softClip(4*half, half); got < half-16 {
+		t.Errorf("softClip(%d) = %d, want close to %d", 4*half, got, half)
+	}
+}
+
+// The soft clip is only applied when selected; the hard clip clamps at full
+// scale.
+func TestScaleOutputClip(t *testing.T) {
+	s := NewSID()
+	scale := sound_sample((4095 * 255 >> 7) * 3 * 15 * 2 / (1 << 16))
+	for _, test := range []struct {
+		clip   OutputClip
+		sample int
+		want   int
+	}{
+		{CLIP_HARD, 20000, 20000},
+		{CLIP_HARD, 40000, 32767},
+		{CLIP_HARD, -40000, -32768},
+		{CLIP_SOFT, 20000, 20000},
+		{CLIP_SOFT, 40000, softClip(40000, 1<<15)},
+		{CLIP_SOFT, -40000, softClip(-40000, 1<<15)},
+	} {
+		s.SetOutputClip(test.clip)
+		if got := s.scaleOutput(sound_sample(test.sample) * scale); got != test.want {
+			t.Errorf("clip %d: %d scaled to %d, want %d", test.clip, test.sample, got, test.want)
+		}
+	}
+}
+
+// stepResponse feeds a step into the filter, and returns the output after
+// each 8 cycles.
+func stepResponse(f *ExternalFilter, level sound_sample, cycles int) []sound_sample {
+	var out []sound_sample
+	for c := 0; c < cycles; c += 8 {
+		f.Clock(8, level)
+		out = append(out, f.Output())
+	}
+	return out
+}
+
+// The time for a step to rise to 1-1/e of its level through the low-pass
+// filter is 1/(2*pi*f), and the time for it to decay to 1/e through the
+// high-pass filter likewise. A corner frequency of 0 leaves out the filter.
+func TestExternalFilterCorners(t *testing.T) {
+	const level = 100000
+	tau := func(freq float64) float64 { return 1e6 / (2 * math.Pi * freq) }
+	// The first output is taken before the step reaches the filters.
+	crossing := func(out []sound_sample, rising bool, at float64) float64 {
+		for i, v := range out[1:] {
+			if rising == (float64(v) >= at) {
+				return float64(i+1) * 8
+			}
+		}
+		return math.Inf(1)
+	}
+
+	f := NewExternalFilter()
+	f.SetSamplingParameter(20000)
+
+	// Low-pass only.
+	for _, lp := range []float64{2000, 8000} {
+		f.Reset()
+		f.SetCornerFrequencies(0, lp)
+		out := stepResponse(f, level, 2000)
+		if got, want := crossing(out, true, level*(1-1/math.E)), tau(lp); math.Abs(got-want) > want/5+8 {
+			t.Errorf("low-pass %.0f Hz: rise time %.0f cycles, want %.0f", lp, got, want)
+		}
+		if got := out[len(out)-1]; got < level*99/100 {
+			t.Errorf("low-pass %.0f Hz: DC level %d, want %d", lp, got, level)
+		}
+	}
+
+	// The low-pass corner is limited to the pass frequency of the sampling.
+	f.Reset()
+	f.SetCornerFrequencies(0, 100000)
+	out := stepResponse(f, level, 2000)
+	if got, want := crossing(out, true, level*(1-1/math.E)), tau(20000); math.Abs(got-want) > want/5+8 {
+		t.Errorf("low-pass above the pass frequency: rise time %.0f cycles, want %.0f", got, want)
+	}
+
+	// High-pass only.
+	for _, hp := range []float64{50, 200} {
+		f.Reset()
+		f.SetCornerFrequencies(hp, 0)
+		out := stepResponse(f, level, 20000)
+		if got, want := crossing(out, false, level/math.E), tau(hp); math.Abs(got-want) > want/5+8 {
+			t.Errorf("high-pass %.0f Hz: decay time %.0f cycles, want %.0f", hp, got, want)
+		}
+	}
+
+	// No filters: the step passes unchanged, after the one sample delay of
+	// the output.
+	f.Reset()
+	f.SetCornerFrequencies(0, 0)
+	out = stepResponse(f, level, 80)
+	for i, v := range out[1:] {
+		if v != level {
+			t.Fatalf("no filters: output %d = %d, want %d", i+1, v, level)
+		}
+	}
+}
+
+// The "none" preset bypasses the output stage, leaving out the mixer DC
+// level of the MOS6581.
+func TestExternalFilterNone(t *testing.T) {
+	for _, name := range []string{"c64", "none"} {
+		if m, ok := ExternalFilterModelByName(name); !ok || m.Name != name {
+			t.Errorf("preset %q = %v, %v", name, m, ok)
+		}
+	}
+	if _, ok := ExternalFilterModelByName("c128"); ok {
+		t.Errorf("unknown preset found")
+	}
+
+	s := NewSID()
+	s.SetModel(MOS6581)
+	s.SetExternalFilter(EXTFILTER_NONE)
+	s.extfilter.Clock(8, 50000)
+	if got, want := s.extfilter.Output(), 50000-s.extfilter.mixer_DC; got != want {
+		t.Errorf("bypassed output = %d, want %d", got, want)
+	}
+
+	s.SetExternalFilter(EXTFILTER_C64)
+	s.extfilter.Reset()
+	s.extfilter.Clock(8, 50000)
+	s.extfilter.Clock(8, 50000)
+	if got := s.extfilter.Output(); got <= 0 || got >= 50000 {
+		t.Errorf("c64 output after 16 cycles = %d, want a partial rise", got)
+	}
+}
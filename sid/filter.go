@@ -6,7 +6,30 @@ import (
 
 const pi = 3.1415926535897932385
 
+// Filter is implemented by the SID filter models, so the model used by a
+// Sid can be swapped; see Sid.SetFilterType.
+type Filter interface {
+	Reset()
+	SetModel(model Model)
+	SetDACModel(model DacModel)
+	SetFilterCurve(curve *FilterCurve)
+	EnableFilter(enable bool)
+
+	WriteFC_LO(fc_lo reg8)
+	WriteFC_HI(fc_hi reg8)
+	WriteRES_FILT(res_filt reg8)
+	WriteMODE_VOL(mode_vol reg8)
+
+	Clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_sample, voice3 sound_sample, ext_in sound_sample)
+	Output() sound_sample
+	State() FilterState
+
+	saveState(sw *stateWriter)
+	loadState(sr *stateReader)
+}
+
 // Filter represents the filter in the SID chip.
+// This is the linear state-variable filter of reSID 0.16.
 type SidFilter struct {
 	// Filter enabled.
 	Enabled bool
@@ -44,6 +67,14 @@ type SidFilter struct {
 
 	// Lookup table ptr for f0, cutoff frequency
 	F0 *[]int16
+
+	// Cutoff frequency D/A converter lookup table.
+	fcDAC []uint16
+
+	// Chip model, and cutoff curve overriding the chip model default, nil
+	// if none.
+	model Model
+	curve *FilterCurve
 }
 
 // ----------------------------------------------------------------------------
@@ -66,6 +97,7 @@ func NewSidFilter() *SidFilter {
 	f.Vnf = 0
 
 	f.EnableFilter(true)
+	f.fcDAC = buildDacTable(11, DAC_IDEAL)
 	f.SetModel(MOS6581)
 	return f
 }
@@ -108,7 +140,7 @@ func (f *SidFilter) WriteMODE_VOL(mode_vol reg8) {
 func (f *SidFilter) SetW0() {
 	// Multiply with 1.048576 to facilitate division by 1 000 000 by right-
 	// shifting 20 times (2 ^ 20 = 1048576).
-	f.w0 = sound_sample(math.Round(2.0 * pi * float64((*f.F0)[f.Fc]) * 1.048576))
+	f.w0 = sound_sample(math.Round(2.0 * pi * float64((*f.F0)[f.fcDAC[f.Fc]]) * 1.048576))
 
 	// Limit f0 to 16kHz to keep 1 cycle SidFilter stable.
 	w0_max_1 := sound_sample(math.Round(2.0 * pi * 16000.0 * 1.048576))
@@ -143,7 +175,20 @@ func (f *SidFilter) EnableFilter(enable bool) {
 	f.Enabled = enable
 }
 
+// Set D/A converter model for the cutoff frequency DAC.
+func (f *SidFilter) SetDACModel(model DacModel) {
+	f.fcDAC = buildDacTable(11, model)
+	f.SetW0()
+}
+
+// Set cutoff curve, or nil to use the curve of the chip model.
+func (f *SidFilter) SetFilterCurve(curve *FilterCurve) {
+	f.curve = curve
+	f.SetModel(f.model)
+}
+
 func (f *SidFilter) SetModel(model Model) {
+	f.model = model
 
 	if model == MOS6581 {
 		// The mixer has a small input DC offset. This is found as follows:
@@ -164,11 +209,59 @@ func (f *SidFilter) SetModel(model Model) {
 		f.F0 = &filter8580
 	}
 
+	if f.curve != nil {
+		f.F0 = &f.curve.F0
+	}
+
 	f.SetW0()
 	f.SetQ()
 }
 
 func (f *SidFilter) Clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_sample, voice3 sound_sample, ext_in sound_sample) {
+	Vi := f.route(voice1, voice2, voice3, ext_in)
+	if !f.Enabled {
+		return
+	}
+
+	// Maximum delta cycles for the filter to work satisfactorily under current
+	// cutoff frequency and resonance constraints is approximately 8.
+	var delta_t_flt CycleCount = 8
+
+	// A single cycle step, as used when clocking cycle by cycle for
+	// interpolation and resampling, is stable up to the 16kHz limit.
+	w0_ceil := f.w0_ceil_dt
+	if delta_t == 1 {
+		w0_ceil = f.w0_ceil_1
+	}
+
+	for delta_t != 0 {
+		if delta_t < delta_t_flt {
+			delta_t_flt = delta_t
+		}
+
+		// delta_t is converted to seconds given a 1MHz clock by dividing
+		// with 1 000 000. This is done in two operations to avoid integer
+		// multiplication overflow.
+
+		// Calculate filter outputs.
+		// Vhp = Vbp/Q - Vlp - Vi;
+		// dVbp = -w0*Vhp*dt;
+		// dVlp = -w0*Vbp*dt;
+		w0_delta_t := sound_sample(int(w0_ceil) * int(delta_t_flt) >> 6)
+
+		dVbp := sound_sample(w0_delta_t * f.Vhp >> 14)
+		dVlp := sound_sample(w0_delta_t * f.Vbp >> 14)
+		f.Vbp -= dVbp
+		f.Vlp -= dVlp
+		f.Vhp = (f.Vbp * f._1024_div_Q >> 10) - f.Vlp - Vi
+
+		delta_t -= delta_t_flt
+	}
+}
+
+// Route voices into or around the filter. Returns the filter input and
+// sets the sum of the non-filtered voices.
+func (f *SidFilter) route(voice1 sound_sample, voice2 sound_sample, voice3 sound_sample, ext_in sound_sample) sound_sample {
 	// Scale each voice down from 20 to 13 bits.
 	voice1 >>= 7
 	voice2 >>= 7
@@ -190,7 +283,7 @@ func (f *SidFilter) Clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_
 	if !f.Enabled {
 		f.Vnf = voice1 + voice2 + voice3 + ext_in
 		f.Vhp, f.Vbp, f.Vlp = 0, 0, 0
-		return
+		return 0
 	}
 
 	// Route voices into or around filter.
@@ -252,33 +345,7 @@ func (f *SidFilter) Clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_
 	default:
 	}
 
-	// Maximum delta cycles for the filter to work satisfactorily under current
-	// cutoff frequency and resonance constraints is approximately 8.
-	var delta_t_flt CycleCount = 8
-
-	for delta_t != 0 {
-		if delta_t < delta_t_flt {
-			delta_t_flt = delta_t
-		}
-
-		// delta_t is converted to seconds given a 1MHz clock by dividing
-		// with 1 000 000. This is done in two operations to avoid integer
-		// multiplication overflow.
-
-		// Calculate filter outputs.
-		// Vhp = Vbp/Q - Vlp - Vi;
-		// dVbp = -w0*Vhp*dt;
-		// dVlp = -w0*Vbp*dt;
-		w0_delta_t := sound_sample(int(f.w0_ceil_dt) * int(delta_t_flt) >> 6)
-
-		dVbp := sound_sample(w0_delta_t * f.Vhp >> 14)
-		dVlp := sound_sample(w0_delta_t * f.Vbp >> 14)
-		f.Vbp -= dVbp
-		f.Vlp -= dVlp
-		f.Vhp = (f.Vbp * f._1024_div_Q >> 10) - f.Vlp - Vi
-
-		delta_t -= delta_t_flt
-	}
+	return Vi
 }
 
 func (f *SidFilter) Output() sound_sample {
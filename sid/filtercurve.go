@@ -0,0 +1,233 @@
+package resid
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FilterCurve maps the 11-bit cutoff frequency register to the cutoff
+// frequency of the filter in Hz.
+//
+// The cutoff curves of MOS6581 chips differ a lot between production runs
+// (R2, R3, R4, R4AR), so a curve can be assigned to each Sid independently
+// of the chip model; see Sid.SetFilterCurve.
+type FilterCurve struct {
+	Name string
+	F0   []int16
+}
+
+// FilterCurveParams defines a cutoff curve parametrically.
+//
+// The curve is an S-shaped transition from MinFreq to MaxFreq. Bias is the
+// position of the midpoint of the transition and Shape its width, both as
+// a fraction of the register range. A Shape of zero gives a straight line,
+// like the MOS8580.
+type FilterCurveParams struct {
+	Bias    float64 `json:"bias"`
+	MinFreq float64 `json:"min"`
+	MaxFreq float64 `json:"max"`
+	Shape   float64 `json:"shape"`
+}
+
+// FilterCurveNames returns the names of the built-in cutoff curves.
+func FilterCurveNames() []string {
+	return []string{"6581", "8580"}
+}
+
+// FilterCurveByName returns the built-in cutoff curve with the given name.
+// "6581" and "8580" are the curves measured for reSID. Curves of other chips
+// are loaded from file, see LoadFilterCurve.
+func FilterCurveByName(name string) (*FilterCurve, bool) {
+	switch name {
+	case "6581":
+		return &FilterCurve{Name: name, F0: filter6581}, true
+	case "8580":
+		return &FilterCurve{Name: name, F0: filter8580}, true
+	}
+	return nil, false
+}
+
+// NewFilterCurve calculates a cutoff curve from parameters.
+func NewFilterCurve(name string, params FilterCurveParams) (*FilterCurve, error) {
+	if !(params.MaxFreq > 0) {
+		return nil, errors.New("filter curve max frequency out of range")
+	}
+	if !(params.MinFreq >= 0 && params.MinFreq <= params.MaxFreq) {
+		return nil, errors.New("filter curve min frequency out of range")
+	}
+	if !(params.Bias >= 0 && params.Bias <= 1) {
+		return nil, errors.New("filter curve bias out of range, expected 0 to 1")
+	}
+	if !(params.Shape >= 0) {
+		return nil, errors.New("filter curve shape out of range, expected 0 or more")
+	}
+
+	// Normalize the S-curve so that it spans exactly MinFreq to MaxFreq.
+	s := func(x float64) float64 {
+		if params.Shape == 0 {
+			return x
+		}
+		return math.Tanh((x - params.Bias) / params.Shape)
+	}
+	s0, s1 := s(0), s(1)
+
+	// A very wide transition is too flat to be normalized.
+	if !(s1-s0 > 1e-6) {
+		return nil, errors.New("filter curve shape too wide")
+	}
+
+	c := &FilterCurve{Name: name, F0: make([]int16, 0x800)}
+	for fc := range c.F0 {
+		y := (s(float64(fc)/0x7ff) - s0) / (s1 - s0)
+		c.F0[fc] = clampFreq(params.MinFreq + y*(params.MaxFreq-params.MinFreq))
+	}
+
+	return c, nil
+}
+
+// ----------------------------------------------------------------------------
+// Load a cutoff curve.
+//
+// Two formats are accepted. The text format has one point per line, the
+// cutoff register value and the frequency in Hz separated by white space.
+// Register values may be given in hex with a 0x or $ prefix. Lines starting
+// with # are comments. Frequencies between the points are interpolated
+// linearly, and held constant beyond the first and last points.
+//
+//	# fc    f0
+//	0x000   220
+//	0x400   1200
+//	0x7ff   18000
+//
+// The JSON format is an object with either a list of points or the
+// parameters of FilterCurveParams:
+//
+//	{"name": "mychip", "points": [[0, 220], [1024, 1200], [2047, 18000]]}
+//	{"name": "mychip", "bias": 0.6, "min": 200, "max": 14000, "shape": 0.16}
+//
+// ----------------------------------------------------------------------------
+func LoadFilterCurve(r io.Reader, name string) (*FilterCurve, error) {
+	br := bufio.NewReader(r)
+
+	// Peek at the first non-space character to tell the formats apart.
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, errors.New("empty filter curve")
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b[0])) {
+			if b[0] == '{' {
+				return loadFilterCurveJSON(br, name)
+			}
+			break
+		}
+		br.ReadByte()
+	}
+
+	var points [][2]float64
+	scanner := bufio.NewScanner(br)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("filter curve line %d: expected cutoff register and frequency", line)
+		}
+		fc, err := parseRegister(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("filter curve line %d: %v", line, err)
+		}
+		f0, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("filter curve line %d: %v", line, err)
+		}
+		points = append(points, [2]float64{float64(fc), f0})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return filterCurveFromPoints(name, points)
+}
+
+func loadFilterCurveJSON(r io.Reader, name string) (*FilterCurve, error) {
+	var doc struct {
+		Name   string       `json:"name"`
+		Points [][2]float64 `json:"points"`
+		FilterCurveParams
+	}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Name != "" {
+		name = doc.Name
+	}
+
+	if doc.Points != nil {
+		return filterCurveFromPoints(name, doc.Points)
+	}
+	if doc.MaxFreq == 0 {
+		return nil, errors.New("filter curve has neither points nor parameters")
+	}
+	return NewFilterCurve(name, doc.FilterCurveParams)
+}
+
+// Interpolate a cutoff curve between points of register value and frequency.
+func filterCurveFromPoints(name string, points [][2]float64) (*FilterCurve, error) {
+	if len(points) == 0 {
+		return nil, errors.New("filter curve has no points")
+	}
+	for _, p := range points {
+		if p[0] < 0 || p[0] > 0x7ff {
+			return nil, fmt.Errorf("filter curve register value %v out of range", p[0])
+		}
+		if p[1] < 0 || p[1] > math.MaxInt16 {
+			return nil, fmt.Errorf("filter curve frequency %v out of range", p[1])
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i][0] < points[j][0] })
+
+	c := &FilterCurve{Name: name, F0: make([]int16, 0x800)}
+	i := 0
+	for fc := range c.F0 {
+		x := float64(fc)
+		for i < len(points)-1 && points[i+1][0] <= x {
+			i++
+		}
+
+		switch {
+		case x <= points[0][0]:
+			c.F0[fc] = clampFreq(points[0][1])
+		case i == len(points)-1:
+			c.F0[fc] = clampFreq(points[i][1])
+		default:
+			x0, y0 := points[i][0], points[i][1]
+			x1, y1 := points[i+1][0], points[i+1][1]
+			c.F0[fc] = clampFreq(y0 + (x-x0)*(y1-y0)/(x1-x0))
+		}
+	}
+
+	return c, nil
+}
+
+func parseRegister(s string) (int, error) {
+	if strings.HasPrefix(s, "$") {
+		s = "0x" + s[1:]
+	}
+	v, err := strconv.ParseInt(s, 0, 32)
+	return int(v), err
+}
+
+func clampFreq(f float64) int16 {
+	return int16(math.Max(0, math.Min(math.MaxInt16, math.Round(f))))
+}
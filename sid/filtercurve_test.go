@@ -0,0 +1,61 @@
+package resid
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterCurveReset(t *testing.T) {
+	for _, model := range []Model{MOS6581, MOS8580} {
+		f := NewSidFilter()
+		f.SetModel(model)
+		want := f.F0
+
+		curve, _ := NewFilterCurve("test", FilterCurveParams{Bias: 0.6, MinFreq: 200, MaxFreq: 14000, Shape: 0.16})
+		f.SetFilterCurve(curve)
+		if f.F0 != &curve.F0 {
+			t.Fatalf("model %d: custom curve not used", model)
+		}
+
+		// Clearing the curve goes back to the curve of the chip model.
+		f.SetFilterCurve(nil)
+		if f.F0 != want {
+			t.Errorf("model %d: curve of the chip model not restored", model)
+		}
+	}
+}
+
+func TestFilterCurveParams(t *testing.T) {
+	tests := []struct {
+		json string
+		ok   bool
+	}{
+		{`{"bias": 0.6, "min": 200, "max": 14000, "shape": 0.16}`, true},
+		{`{"bias": 0.6, "min": 200, "max": 14000, "shape": 0}`, true},
+		{`{"bias": 0, "min": 200, "max": 14000, "shape": 1e-9}`, true},
+		{`{"bias": 1.5, "min": 200, "max": 14000, "shape": 0.01}`, false},
+		{`{"bias": -3, "min": 200, "max": 14000, "shape": 0.16}`, false},
+		{`{"bias": 0.6, "min": 200, "max": 14000, "shape": -0.1}`, false},
+		{`{"bias": 0.6, "min": 200, "max": 14000, "shape": 1e9}`, false},
+		{`{"bias": 0.6, "min": 300, "max": 200, "shape": 0.16}`, false},
+		{`{"bias": 0.6, "min": 200, "max": -1, "shape": 0.16}`, false},
+		{`{"bias": 0.6, "min": 200, "shape": 0.16}`, false},
+	}
+
+	for _, test := range tests {
+		curve, err := LoadFilterCurve(strings.NewReader(test.json), "test")
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: no error", test.json)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.json, err)
+			continue
+		}
+		if curve.F0[0] != 200 || curve.F0[0x7ff] != 14000 {
+			t.Errorf("%s: curve spans %d to %d Hz, want 200 to 14000 Hz", test.json, curve.F0[0], curve.F0[0x7ff])
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package resid
+
+import "math"
+
+// FilterFP is a non-linear model of the SID filter, in the spirit of the
+// two-integrator-loop models of later reSID versions and reSIDfp.
+//
+// The filter is a two-integrator-loop biquadratic filter. Each integrator
+// is an op-amp with a capacitor in its feedback loop, fed through a VCR
+// (an n-MOS transistor used as a voltage controlled resistor). The gate
+// voltage of the VCR is set by the cutoff frequency DAC.
+//
+// The current through the VCR is modeled with the quadratic n-MOS
+// equation, which covers both the triode and the saturation region:
+//
+//	I = K/2*((Vg - Vt - Vd)^2 - (Vg - Vt - Vs)^2)
+//
+// where each squared term is zero when the transistor is cut off at that
+// terminal. For small signals this is a resistor with conductance
+// K*(Vg - Vt), so the cutoff frequency from the F0 table is kept; as the
+// signal level rises the conductance drops and the transistor saturates.
+// This gives the level dependent cutoff and the asymmetric distortion of
+// the MOS6581. The MOS8580 has a much higher gate overdrive and behaves
+// almost linearly.
+//
+// The op-amp outputs are limited towards the rails. The integrators slow
+// down as they approach a rail and stop there, while the output of the
+// summing op-amp is soft clipped.
+//
+// Registers, routing and output mixing are shared with the linear filter.
+type FilterFP struct {
+	SidFilter
+
+	// Integrator and summer outputs, normalized so that one voice at full
+	// level has an amplitude of ~1.
+	vhp, vbp, vlp float64
+
+	// VCR gate overdrive Vg - Vt at the nominal cutoff, and op-amp output
+	// limit, normalized.
+	vgt  float64
+	vsat float64
+}
+
+// Normalization of the 13-bit filter input samples.
+const fpScale = 4096.0
+
+// ----------------------------------------------------------------------------
+// Constructor.
+// ----------------------------------------------------------------------------
+func NewFilterFP() *FilterFP {
+	f := &FilterFP{SidFilter: *NewSidFilter()}
+	f.SetModel(MOS6581)
+	return f
+}
+
+func (f *FilterFP) Reset() {
+	f.SidFilter.Reset()
+	f.vhp, f.vbp, f.vlp = 0, 0, 0
+}
+
+func (f *FilterFP) SetModel(model Model) {
+	f.SidFilter.SetModel(model)
+
+	// The VCR gate overdrive and the op-amp limit are estimates in units of
+	// the amplitude of one voice, not measurements; they are chosen for the
+	// behavior they give.
+	//
+	// A signal v at the VCR scales its conductance by 1 - v/(2*vgt), so with
+	// an overdrive of 3.0 the cutoff of the MOS6581 moves by about 17% over
+	// the swing of a voice at full level. The VCR of the MOS8580 sees a gate
+	// voltage far above the signal; 24.0 keeps the movement at about 2%.
+	//
+	// The op-amp limit is the swing left between the DC level of the filter
+	// and the supply rails. 4.0 lets the MOS6581 clip on a few voices with
+	// resonance, as it does in C64 recordings; the MOS8580 has more headroom
+	// for its smaller voice outputs, 6.0.
+	if model == MOS6581 {
+		f.vgt = 3.0
+		f.vsat = 4.0
+	} else {
+		f.vgt = 24.0
+		f.vsat = 6.0
+	}
+}
+
+// Current through the VCR from an integrator input at voltage v into the
+// virtual ground, for the transconductance k.
+func (f *FilterFP) vcr(k float64, v float64) float64 {
+	vgd := f.vgt
+	vgs := math.Max(f.vgt-v, 0)
+	return k / 2 * (vgd*vgd - vgs*vgs)
+}
+
+// Summing op-amp output limited towards the rails.
+func (f *FilterFP) opamp(v float64) float64 {
+	return f.vsat * math.Tanh(v/f.vsat)
+}
+
+// Integrator output v changed by dv, slowing down towards the rails.
+func (f *FilterFP) integrate(v float64, dv float64) float64 {
+	if (dv > 0) == (v > 0) {
+		x := v / f.vsat
+		dv *= 1 - x*x
+	}
+	return math.Max(-f.vsat, math.Min(f.vsat, v+dv))
+}
+
+func (f *FilterFP) Clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_sample, voice3 sound_sample, ext_in sound_sample) {
+	Vi := f.route(voice1, voice2, voice3, ext_in)
+	if !f.Enabled {
+		f.vhp, f.vbp, f.vlp = 0, 0, 0
+		return
+	}
+
+	vi := float64(Vi) / fpScale
+
+	// The integrators are stepped once per cycle; delta_t is converted to
+	// seconds given a 1MHz clock, like in the linear filter. The cutoff is
+	// limited to 16kHz to keep the single cycle integration stable.
+	const dt = 1e-6
+	w0 := float64(f.w0_ceil_1) / 1.048576
+	k := w0 / f.vgt
+	_1_div_Q := float64(f._1024_div_Q) / 1024
+
+	for ; delta_t > 0; delta_t-- {
+		// Vhp = Vbp/Q - Vlp - Vi;
+		// dVbp = -I(Vhp)*dt;
+		// dVlp = -I(Vbp)*dt;
+		f.vbp = f.integrate(f.vbp, -f.vcr(k, f.vhp)*dt)
+		f.vlp = f.integrate(f.vlp, -f.vcr(k, f.vbp)*dt)
+		f.vhp = f.opamp(f.vbp*_1_div_Q - f.vlp - vi)
+	}
+
+	// Output mixing is done by the linear filter.
+	f.Vhp = sound_sample(f.vhp * fpScale)
+	f.Vbp = sound_sample(f.vbp * fpScale)
+	f.Vlp = sound_sample(f.vlp * fpScale)
+}
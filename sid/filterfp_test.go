@@ -0,0 +1,88 @@
+package resid
+
+import (
+	"math"
+	"testing"
+)
+
+// runFilterFP feeds a sine of the given amplitude in voices and frequency
+// through the low-pass filter, and returns the peak low-pass and high-pass
+// outputs once the filter has settled.
+func runFilterFP(model Model, res reg8, amplitude float64, freq float64) (float64, float64) {
+	f := NewFilterFP()
+	f.SetModel(model)
+	f.WriteFC_LO(0x00)
+	f.WriteFC_HI(0x40)
+	f.WriteRES_FILT(res<<4 | 0x01)
+	f.WriteMODE_VOL(0x1f)
+
+	var lp, hp float64
+	for c := 0; c < 200000; c++ {
+		v := sound_sample(amplitude * fpScale * 128 * math.Sin(2*math.Pi*freq*float64(c)/1e6))
+		f.Clock(1, v, 0, 0, 0)
+		if c >= 100000 {
+			lp = math.Max(lp, math.Abs(f.vlp))
+			hp = math.Max(hp, math.Abs(f.vhp))
+		}
+	}
+	return lp, hp
+}
+
+// The cutoff frequency of the filter set to fc register 0x200.
+func filterFPCutoff(model Model) float64 {
+	f := NewFilterFP()
+	f.SetModel(model)
+	f.WriteFC_LO(0x00)
+	f.WriteFC_HI(0x40)
+	return float64(f.w0_ceil_1) / 1.048576 / (2 * math.Pi)
+}
+
+// Above the cutoff, the gain of the low-pass output depends on the level on
+// the MOS6581, as the VCR conductance moves with the signal. The MOS8580
+// stays close to linear.
+func TestFilterFPLevelDependentCutoff(t *testing.T) {
+	for _, test := range []struct {
+		model    Model
+		min, max float64
+	}{
+		{MOS6581, 1.3, 2},
+		{MOS8580, 1, 1.1},
+	} {
+		freq := 3 * filterFPCutoff(test.model)
+		quiet, _ := runFilterFP(test.model, 0, 0.05, freq)
+		loud, _ := runFilterFP(test.model, 0, 1, freq)
+		if ratio := (loud / 1) / (quiet / 0.05); ratio < test.min || ratio > test.max {
+			t.Errorf("model %d: gain at full level %.2f times the gain at low level, want %.2f to %.2f",
+				test.model, ratio, test.min, test.max)
+		}
+	}
+}
+
+// With resonance, a loud signal at the cutoff drives the op-amps into their
+// limit, which they do not pass.
+func TestFilterFPClipping(t *testing.T) {
+	for _, test := range []struct {
+		model Model
+		vsat  float64
+	}{
+		{MOS6581, 4},
+		{MOS8580, 6},
+	} {
+		freq := filterFPCutoff(test.model)
+		lp, hp := runFilterFP(test.model, 0xf, 10, freq)
+		for _, out := range []struct {
+			name string
+			peak float64
+		}{{"low-pass", lp}, {"high-pass", hp}} {
+			if out.peak > test.vsat || out.peak < test.vsat*3/4 {
+				t.Errorf("model %d: %s peak %.2f, want clipping below %.1f", test.model, out.name, out.peak, test.vsat)
+			}
+		}
+
+		// A quiet signal is not clipped.
+		lp, _ = runFilterFP(test.model, 0xf, 0.1, freq)
+		if lp > test.vsat/4 {
+			t.Errorf("model %d: low-pass peak %.2f at low level, want no clipping", test.model, lp)
+		}
+	}
+}
@@ -1,17 +1,65 @@
 package resid
 
+import "math"
+
+// Resampling constants.
+// The error in interpolated lookup is bounded by 1.234/L^2,
+// while the error in non-interpolated lookup is bounded by
+// 0.7854/L + 0.4113/L^2, see
+// http://www-ccrma.stanford.edu/~jos/resample/Choice_Table_Size.html
+// For a resolution of 16 bits this yields L >= 285 and L >= 51473,
+// respectively.
+const (
+	FIR_N               = 125
+	FIR_RES_INTERPOLATE = 285
+	FIR_RES_FAST        = 51473
+	FIR_SHIFT           = 15
+	RINGSIZE            = 16384
+
+	// Paddle measurement period in cycles.
+	POT_PERIOD = 512
+
+	// Fixpoint constants (16.16 bits).
+	FIXP_SHIFT = 16
+	FIXP_MASK  = 0xffff
+)
+
 type Sid struct {
 	voice           [3]*Voice
-	filter          *SidFilter
+	filter          Filter
+	filterRegs      [4]reg8
+	filterType      FilterType
+	model           Model
+	dac             DacModel
+	filterCurve     *FilterCurve
 	extfilter       *ExternalFilter
 	potx            reg8
 	poty            reg8
+	potxIn          reg8
+	potyIn          reg8
+	potCycles       CycleCount
 	busValue        reg8
-	busValueTTL     CycleCount
+	busValueAge     CycleCount
+	busValueFade    []CycleCount
 	clkFreq         float64
 	extIn           sound_sample
+	input           InputSource
+	clip            OutputClip
+	taps            *voiceTaps
+	tapBuf          [][3]VoiceTap
 	cyclesPerSample CycleCount
 	sampleOffset    CycleCount
+	sampling        SamplingMethod
+	samplePrev      int16
+
+	// Ring buffer with overflow for contiguous storage of RINGSIZE samples.
+	sample      []int16
+	sampleIndex int
+
+	// FIR_RES filter tables (FIR_N*FIR_RES).
+	fir    []int16
+	firN   int
+	firRES int
 }
 
 var cntdwn int = 100000
@@ -26,6 +74,8 @@ func NewSID() *Sid {
 	sid.voice[1].SetSyncSource(sid.voice[0])
 	sid.voice[2].SetSyncSource(sid.voice[1])
 
+	sid.model = MOS6581
+	sid.dac = DAC_IDEAL
 	sid.filter = NewSidFilter()
 	sid.extfilter = NewExternalFilter()
 
@@ -33,7 +83,8 @@ func NewSID() *Sid {
 	sid.SetSamplingParameters(985248, SAMPLE_FAST, 22050)
 
 	sid.busValue = 0
-	sid.busValueTTL = 0
+	sid.busValueAge = 0
+	sid.busValueFade = busValueFade6581
 
 	sid.extIn = 0
 
@@ -47,9 +98,11 @@ func (s *Sid) Reset() {
 
 	s.filter.Reset()
 	s.extfilter.Reset()
+	s.filterRegs = [4]reg8{}
+	s.resetTaps()
 
 	s.busValue = 0
-	s.busValueTTL = 0
+	s.busValueAge = 0
 }
 
 // ----------------------------------------------------------------------------
@@ -57,35 +110,184 @@ func (s *Sid) Reset() {
 // ----------------------------------------------------------------------------
 
 func (s *Sid) SetModel(model Model) {
+	s.model = model
+
 	s.voice[0].SetModel(model)
 	s.voice[1].SetModel(model)
 	s.voice[2].SetModel(model)
 
 	s.filter.SetModel(model)
 	s.extfilter.SetModel(model)
+	s.resetTaps()
+
+	if model == MOS6581 {
+		s.busValueFade = busValueFade6581
+	} else {
+		s.busValueFade = busValueFade8580
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Set D/A converter model for the waveform, envelope and cutoff DACs.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetDACModel(model DacModel) {
+	s.dac = model
+
+	s.voice[0].SetDACModel(model)
+	s.voice[1].SetDACModel(model)
+	s.voice[2].SetDACModel(model)
+
+	s.filter.SetDACModel(model)
+	s.resetTaps()
+}
+
+// ----------------------------------------------------------------------------
+// Set paddle inputs.
+// The value is the paddle position as read from POTX/POTY, 0 to 255. The
+// registers follow the inputs at the end of the current measurement period
+// of 512 cycles.
+// A 1351 mouse in proportional mode sets the position modulo 64 in bits 1-6.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetPotX(value uint8) {
+	s.potxIn = reg8(value)
+}
+
+func (s *Sid) SetPotY(value uint8) {
+	s.potyIn = reg8(value)
+}
+
+func (s *Sid) SetPaddles(x uint8, y uint8) {
+	s.SetPotX(x)
+	s.SetPotY(y)
+}
+
+// ----------------------------------------------------------------------------
+// Set combined waveform tables, or nil to use the tables of the chip model.
+// Incomplete tables are rejected, keeping the tables in use.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetWaveTables(tables *WaveTables) error {
+	if tables != nil {
+		if err := tables.validate(); err != nil {
+			return err
+		}
+	}
+	s.voice[0].Wave.SetWaveTables(tables)
+	s.voice[1].Wave.SetWaveTables(tables)
+	s.voice[2].Wave.SetWaveTables(tables)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Set filter cutoff curve, or nil to use the curve of the chip model.
+// The curve is kept when the chip model or filter implementation changes.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetFilterCurve(curve *FilterCurve) {
+	s.filterCurve = curve
+	s.filter.SetFilterCurve(curve)
+	s.resetTaps()
+}
+
+// ----------------------------------------------------------------------------
+// Set output stage of the C64 board. A model with both corner frequencies
+// at 0 turns off the external filter, removing the mixer DC level instead.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetExternalFilter(model ExternalFilterModel) {
+	s.extfilter.EnableFilter(model.HighPass != 0 || model.LowPass != 0)
+	s.extfilter.SetCornerFrequencies(model.HighPass, model.LowPass)
+	s.resetTaps()
+}
+
+// ----------------------------------------------------------------------------
+// Set clipping of the output to 16 bits.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetOutputClip(clip OutputClip) {
+	s.clip = clip
+}
+
+// ----------------------------------------------------------------------------
+// Select filter implementation.
+// The new filter takes over the chip model, DAC model and filter registers,
+// while the filter state starts from zero.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetFilterType(filterType FilterType) {
+	s.filterType = filterType
+	s.filter = s.newFilter()
+	s.resetTaps()
+}
+
+// newFilter returns a filter of the selected implementation, set up with
+// the chip model, DAC model and filter registers.
+func (s *Sid) newFilter() Filter {
+	var f Filter
+	switch s.filterType {
+	case FILTER_FP:
+		f = NewFilterFP()
+	default:
+		f = NewSidFilter()
+	}
+
+	f.SetModel(s.model)
+	f.SetDACModel(s.dac)
+	f.SetFilterCurve(s.filterCurve)
+	f.WriteFC_LO(s.filterRegs[0])
+	f.WriteFC_HI(s.filterRegs[1])
+	f.WriteRES_FILT(s.filterRegs[2])
+	f.WriteMODE_VOL(s.filterRegs[3])
+	return f
 }
 
 // ----------------------------------------------------------------------------
 // Write 16-bit sample to audio input.
-// NB! The caller is responsible for keeping the value within 16 bits.
+// There is room for a 16-bit signal on top of a full scale DC level, as fed
+// by the MOS8580 "digi boost" hardware hack; the sample is clipped to 17
+// bits.
 // Note that to mix in an external audio signal, the signal should be
 // resampled to 1MHz first to avoid sampling noise.
 // ----------------------------------------------------------------------------
 func (s *Sid) Input(sample sound_sample) {
+	sample = max(-0x10000, min(0xffff, sample))
+
 	// Voice outputs are 20 bits. Scale up to match three voices in order
 	// to facilitate simulation of the MOS8580 "digi boost" hardware hack.
 	s.extIn = (sample << 4) * 3
 }
 
+// InputSource feeds an audio signal into the EXT IN pin, see
+// Sid.SetInputSource.
+type InputSource interface {
+	// Sample advances the input by delta_t cycles and returns the input at
+	// that point in time as a 16-bit sample, on top of any DC level; see
+	// Input.
+	Sample(delta_t CycleCount) int
+}
+
+// ----------------------------------------------------------------------------
+// Set source of the audio input, or nil to keep the input from Input.
+// The source is read each time the chip is clocked. For a clean signal it
+// must be resampled to the chip clock, and the chip clocked one cycle at a
+// time, as is done by the resampling sampling methods.
+// ----------------------------------------------------------------------------
+func (s *Sid) SetInputSource(source InputSource) {
+	s.input = source
+}
+
 // ----------------------------------------------------------------------------
 // Read sample from audio output.
 // ----------------------------------------------------------------------------
 func (s *Sid) Output() int {
+	return s.scaleOutput(s.extfilter.Output())
+}
+
+// scaleOutput scales an external filter output to 16 bits and clips it.
+func (s *Sid) scaleOutput(output sound_sample) int {
 	rng := int(1 << 16)
 	half := int(rng >> 1)
 	//var sample int = int(s.extfilter.Output()) / ((4095 * 255 >> 7) * 3 * 15 * 2 / rng)
-	var sample int = int(s.extfilter.Output())
+	var sample int = int(output)
 	sample /= ((4095 * 255 >> 7) * 3 * 15 * 2 / rng)
+	if s.clip == CLIP_SOFT {
+		sample = softClip(sample, half)
+	}
 	if sample >= half {
 		return half - 1
 	}
@@ -95,22 +297,34 @@ func (s *Sid) Output() int {
 	return sample
 }
 
+// softClip passes samples below 3/4 of full scale, and compresses samples
+// above it with a tanh curve approaching full scale. The slope is 1 at the
+// knee, so that the curve has no corner.
+func softClip(sample int, half int) int {
+	knee := half * 3 / 4
+	if sample <= knee && sample >= -knee {
+		return sample
+	}
+	width := float64(half - knee)
+	if sample > 0 {
+		return knee + int(width*math.Tanh(float64(sample-knee)/width))
+	}
+	return -knee - int(width*math.Tanh(float64(-sample-knee)/width))
+}
+
 // ----------------------------------------------------------------------------
 // Read registers.
 //
 // Reading a write only register returns the last byte written to any SID
 // register. The individual bits in this value start to fade down towards
-// zero after a few cycles. All bits reach zero within approximately
-// $2000 - $4000 cycles.
+// zero after a few cycles. All bits reach zero within $1d00 cycles on the
+// MOS6581.
 // It has been claimed that this fading happens in an orderly fashion, however
 // sampling of write only registers reveals that this is not the case.
-// NB! This is not correctly modeled.
-// The actual use of write only registers has largely been made in the belief
-// that all SID registers are readable. To support this belief the read
-// would have to be done immediately after a write to the same register
-// (remember that an intermediate write to another register would yield that
-// value instead). With this in mind we return the last value written to
-// any SID register for $2000 cycles without modeling the bit fading.
+// The fading is modeled by giving each bit its own life time, with all bits
+// gone after the life time measured for the whole value, see
+// busValueFade6581. The MOS8580 holds the bus value considerably longer,
+// $a2000 cycles.
 // ----------------------------------------------------------------------------
 func (s *Sid) Read(offset uint8) uint8 {
 	switch offset {
@@ -127,13 +341,46 @@ func (s *Sid) Read(offset uint8) uint8 {
 	}
 }
 
+// Life time in cycles of each bit of the bus value. reSID gives a single
+// life time for the whole value, measured at $1d00 cycles on the MOS6581 and
+// $a2000 cycles on the MOS8580; after it all bits are zero here too. Which
+// bits go first has not been measured, so the order and spacing of the bits
+// within the second half of that time are synthetic, see fadeTimes.
+var busValueFade6581 = fadeTimes(0x1d00, 8)
+var busValueFade8580 = fadeTimes(0xa2000, 8)
+
+// fadeTimes returns synthetic life times for n bits that are all gone after
+// ttl cycles. The bits are spread evenly over the second half of ttl, in a
+// fixed order that is not the order of significance, so reads stay
+// deterministic.
+func fadeTimes(ttl CycleCount, n int) []CycleCount {
+	times := make([]CycleCount, n)
+	for bit := range times {
+		order := CycleCount(bit * 3 % n)
+		times[bit] = ttl/2 + ttl/2*(order+1)/CycleCount(n)
+	}
+	return times
+}
+
+// fadeMask returns a mask of the bits that are still alive age cycles after
+// they were set, given the life time of each bit.
+func fadeMask(ttl []CycleCount, age CycleCount) uint32 {
+	var mask uint32
+	for bit, t := range ttl {
+		if age < t {
+			mask |= 1 << bit
+		}
+	}
+	return mask
+}
+
 // ----------------------------------------------------------------------------
 // Write registers.
 // ----------------------------------------------------------------------------
 func (s *Sid) Write(offset uint8, val uint8) {
 	value := reg8(val)
 	s.busValue = reg8(value)
-	s.busValueTTL = 0x2000
+	s.busValueAge = 0
 
 	switch offset {
 	case 0x00:
@@ -179,13 +426,29 @@ func (s *Sid) Write(offset uint8, val uint8) {
 	case 0x14:
 		s.voice[2].Envelope.WriteSUSTAIN_RELEASE(value)
 	case 0x15:
+		s.filterRegs[0] = value
 		s.filter.WriteFC_LO(value)
+		for _, f := range s.tapFilters() {
+			f.WriteFC_LO(value)
+		}
 	case 0x16:
+		s.filterRegs[1] = value
 		s.filter.WriteFC_HI(value)
+		for _, f := range s.tapFilters() {
+			f.WriteFC_HI(value)
+		}
 	case 0x17:
+		s.filterRegs[2] = value
 		s.filter.WriteRES_FILT(value)
+		for _, f := range s.tapFilters() {
+			f.WriteRES_FILT(value)
+		}
 	case 0x18:
+		s.filterRegs[3] = value
 		s.filter.WriteMODE_VOL(value)
+		for _, f := range s.tapFilters() {
+			f.WriteMODE_VOL(value)
+		}
 	default:
 	}
 }
@@ -202,9 +465,43 @@ func (s *Sid) Mute(channel reg8, enable bool) {
 	s.voice[channel].Mute(enable)
 }
 
+// ----------------------------------------------------------------------------
+// Setting of SID sampling parameters.
+//
+// Use a clock freqency of 985248Hz for PAL C64, 1022730Hz for NTSC C64.
+// The default end of passband frequency is pass_freq = 0.9*sample_freq/2
+// for sample frequencies up to ~ 44.1kHz, and 20kHz for higher sample
+// frequencies.
+//
+// For resampling, the ratio between the clock frequency and the sample
+// frequency is limited as follows:
+//
+//	125*clock_freq/sample_freq < 16384
+//
+// E.g. provided a clock frequency of ~ 1MHz, the sample frequency can not
+// be set lower than ~ 8kHz. A lower sample frequency would make the
+// resampling code overfill its 16k sample ring buffer.
+//
+// The end of passband frequency is also limited:
+//
+//	pass_freq <= 0.9*sample_freq/2
+//
+// E.g. for a 44.1kHz sampling rate the end of passband frequency is limited
+// to slightly below 20kHz. This constraint ensures that the FIR table is
+// not overfilled.
+// ----------------------------------------------------------------------------
 func (s *Sid) SetSamplingParameters(clock_freq float64, method SamplingMethod, sample_freq float64) bool {
 	pass_freq := float64(-1)
 	filter_scale := 0.97
+
+	// Check resampling constraints.
+	if method == SAMPLE_RESAMPLE_INTERPOLATE || method == SAMPLE_RESAMPLE_FAST {
+		// Check whether the sample ring buffer would overfill.
+		if FIR_N*clock_freq/sample_freq >= RINGSIZE {
+			return false
+		}
+	}
+
 	// The default passband limit is 0.9*sample_freq/2 for sample
 	// frequencies below ~ 44.1kHz, and 20kHz for higher sample frequencies.
 	if pass_freq < 0 {
@@ -227,18 +524,115 @@ func (s *Sid) SetSamplingParameters(clock_freq float64, method SamplingMethod, s
 
 	// Set the external filter to the pass freq
 	s.extfilter.SetSamplingParameter(pass_freq)
+	s.resetTaps()
 	s.clkFreq = clock_freq
-	//   s.sampling = method;
+	s.sampling = method
 
 	s.cyclesPerSample =
-		CycleCount(clock_freq/sample_freq*(1<<16) + 0.5)
+		CycleCount(clock_freq/sample_freq*(1<<FIXP_SHIFT) + 0.5)
 
 	s.sampleOffset = 0
-	//   s.sample_prev = 0;
+	s.samplePrev = 0
+
+	// FIR initialization is only necessary for resampling.
+	if method != SAMPLE_RESAMPLE_INTERPOLATE && method != SAMPLE_RESAMPLE_FAST {
+		s.sample = nil
+		s.fir = nil
+		return true
+	}
+
+	// 16 bits -> -96dB stopband attenuation.
+	A := -20 * math.Log10(1.0/(1<<16))
+	// A fraction of the bandwidth is allocated to the transition band,
+	dw := (1 - 2*pass_freq/sample_freq) * pi
+	// The cutoff frequency is midway through the transition band.
+	wc := (2*pass_freq/sample_freq + 1) * pi / 2
+
+	// For calculation of beta and N see the reference for the kaiserord
+	// function in the MATLAB Signal Processing Toolbox:
+	// http://www.mathworks.com/access/helpdesk/help/toolbox/signal/kaiserord.html
+	beta := 0.1102 * (A - 8.7)
+	I0beta := i0(beta)
+
+	// The filter order will maximally be 124 with the current constraints.
+	// N >= (96.33 - 7.95)/(2.285*0.1*pi) -> N >= 123
+	// The filter order is equal to the number of zero crossings, i.e.
+	// it should be an even number (sinc is symmetric about x = 0).
+	N := int((A-7.95)/(2.285*dw) + 0.5)
+	N += N & 1
+
+	f_samples_per_cycle := sample_freq / clock_freq
+	f_cycles_per_sample := clock_freq / sample_freq
+
+	// The filter length is equal to the filter order + 1.
+	// The filter length must be an odd number (sinc is symmetric about x = 0).
+	s.firN = int(float64(N)*f_cycles_per_sample) + 1
+	s.firN |= 1
+
+	// We clamp the filter table resolution to 2^n, making the fixpoint
+	// sample_offset a whole multiple of the filter table resolution.
+	res := FIR_RES_FAST
+	if method == SAMPLE_RESAMPLE_INTERPOLATE {
+		res = FIR_RES_INTERPOLATE
+	}
+	n := int(math.Ceil(math.Log(float64(res)/f_cycles_per_sample) / math.Log(2.0)))
+	s.firRES = 1 << n
+
+	s.fir = make([]int16, s.firN*s.firRES)
+
+	// Calculate fir_RES FIR tables for linear interpolation.
+	for i := 0; i < s.firRES; i++ {
+		fir_offset := i*s.firN + s.firN/2
+		j_offset := float64(i) / float64(s.firRES)
+		// Calculate FIR table. This is the sinc function, weighted by the
+		// Kaiser window.
+		for j := -s.firN / 2; j <= s.firN/2; j++ {
+			jx := float64(j) - j_offset
+			wt := wc * jx / f_cycles_per_sample
+			temp := jx / float64(s.firN/2)
+			Kaiser := 0.0
+			if math.Abs(temp) <= 1 {
+				Kaiser = i0(beta*math.Sqrt(1-temp*temp)) / I0beta
+			}
+			sincwt := 1.0
+			if math.Abs(wt) >= 1e-6 {
+				sincwt = math.Sin(wt) / wt
+			}
+			val := (1 << FIR_SHIFT) * filter_scale * f_samples_per_cycle * wc / pi * sincwt * Kaiser
+			s.fir[fir_offset+j] = int16(math.Floor(val + 0.5))
+		}
+	}
+
+	// Allocate and clear sample buffer.
+	s.sample = make([]int16, RINGSIZE*2)
+	s.sampleIndex = 0
 
 	return true
 }
 
+// ----------------------------------------------------------------------------
+// Zeroth order modified Bessel function of the first kind.
+// ----------------------------------------------------------------------------
+func i0(x float64) float64 {
+	// Max error acceptable in I0.
+	const I0e = 1e-6
+
+	sum, u, n := 1.0, 1.0, 1.0
+	halfx := x / 2.0
+
+	for {
+		temp := halfx / n
+		n++
+		u *= temp * temp
+		sum += u
+		if u < I0e*sum {
+			break
+		}
+	}
+
+	return sum
+}
+
 // ----------------------------------------------------------------------------
 // SID clocking - delta_t cycles.
 // ----------------------------------------------------------------------------
@@ -249,11 +643,24 @@ func (s *Sid) Clock(delta_t CycleCount) {
 		return
 	}
 
-	// Age bus value.
-	s.busValueTTL -= delta_t
-	if s.busValueTTL <= 0 {
-		s.busValue = 0
-		s.busValueTTL = 0
+	// The paddle inputs are measured over a period of 512 cycles, after
+	// which the POTX and POTY registers are updated.
+	s.potCycles += delta_t
+	if s.potCycles >= POT_PERIOD {
+		s.potx = s.potxIn
+		s.poty = s.potyIn
+		s.potCycles %= POT_PERIOD
+	}
+
+	// Age bus value, fading the bits towards zero one by one.
+	if s.busValue != 0 {
+		s.busValueAge += delta_t
+		s.busValue &= reg8(fadeMask(s.busValueFade, s.busValueAge))
+	}
+
+	// Read audio input.
+	if s.input != nil {
+		s.Input(sound_sample(s.input.Sample(delta_t)))
 	}
 
 	// Clock amplitude modulators.
@@ -314,12 +721,16 @@ func (s *Sid) Clock(delta_t CycleCount) {
 	}
 
 	// Clock filter.
-	s.filter.Clock(delta_t, s.voice[0].Output(), s.voice[1].Output(), s.voice[2].Output(),
-		s.extIn)
+	voice1, voice2, voice3 := s.voice[0].Output(), s.voice[1].Output(), s.voice[2].Output()
+	s.filter.Clock(delta_t, voice1, voice2, voice3, s.extIn)
 
 	// Clock external filter.
 	s.extfilter.Clock(delta_t, s.filter.Output())
 
+	if s.taps != nil {
+		s.taps.clock(delta_t, voice1, voice2, voice3)
+	}
+
 	// if cntdwn > 0 {
 	// 	cntdwn--
 	// 	fmt.Printf("%d v0=%d ve0=%d vw0=%d v1=%d ve1=%d vw1=%d v2=%d ve2=%d vw2=%d f=%d ef=%d sid=%d\n", cntdwn,
@@ -329,3 +740,309 @@ func (s *Sid) Clock(delta_t CycleCount) {
 	// 		s.filter.Output(), s.extfilter.Output(), s.Output())
 	// }
 }
+
+// ----------------------------------------------------------------------------
+// SID clocking with audio sampling.
+// Fixpoint arithmetics is used.
+//
+// The example below shows how to clock the SID a specified amount of cycles
+// while producing audio output:
+//
+//	for delta_t > 0 {
+//		bufindex += sid.ClockSamples(&delta_t, buf[bufindex:], buflength-bufindex, 1)
+//		write(dsp, buf, bufindex*2)
+//		bufindex = 0
+//	}
+//
+// ----------------------------------------------------------------------------
+func (s *Sid) ClockSamples(delta_t *CycleCount, buf []int16, n int, interleave int) int {
+	switch s.sampling {
+	case SAMPLE_INTERPOLATE:
+		return s.clockInterpolate(delta_t, buf, n, interleave)
+	case SAMPLE_RESAMPLE_INTERPOLATE:
+		return s.clockResampleInterpolate(delta_t, buf, n, interleave)
+	case SAMPLE_RESAMPLE_FAST:
+		return s.clockResampleFast(delta_t, buf, n, interleave)
+	default:
+		return s.clockFast(delta_t, buf, n, interleave)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking of a cycle budget into a mono sample buffer.
+// The chip is clocked until either delta_t cycles have passed or the buffer
+// is full. The sample timing is kept in 16.16 fixpoint, so no fractional
+// cycles are lost between calls. Returns the number of samples written;
+// cycles that did not fit in the buffer are left in delta_t.
+// ----------------------------------------------------------------------------
+func (s *Sid) ClockBuffer(delta_t *CycleCount, buf []int16) int {
+	return s.ClockSamples(delta_t, buf, len(buf), 1)
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking of a cycle budget into a mono sample buffer, also storing the
+// voice taps at each sample in taps, which must be as long as buf. See
+// EnableTaps.
+// ----------------------------------------------------------------------------
+func (s *Sid) ClockBufferTaps(delta_t *CycleCount, buf []int16, taps [][3]VoiceTap) int {
+	s.tapBuf = taps
+	n := s.ClockSamples(delta_t, buf, len(buf), 1)
+	s.tapBuf = nil
+	return n
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking with audio sampling - delta clocking picking nearest sample.
+// ----------------------------------------------------------------------------
+func (s *Sid) clockFast(delta_t *CycleCount, buf []int16, n int, interleave int) int {
+	i := 0
+
+	for {
+		next_sample_offset := s.sampleOffset + s.cyclesPerSample + (1 << (FIXP_SHIFT - 1))
+		delta_t_sample := next_sample_offset >> FIXP_SHIFT
+		if delta_t_sample > *delta_t {
+			break
+		}
+		if i >= n {
+			return i
+		}
+		s.Clock(delta_t_sample)
+		*delta_t -= delta_t_sample
+		s.sampleOffset = (next_sample_offset & FIXP_MASK) - (1 << (FIXP_SHIFT - 1))
+		buf[i*interleave] = int16(s.Output())
+		if s.tapBuf != nil {
+			s.tapBuf[i] = s.Taps()
+		}
+		i++
+	}
+
+	s.Clock(*delta_t)
+	s.sampleOffset -= *delta_t << FIXP_SHIFT
+	*delta_t = 0
+	return i
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking with audio sampling - cycle based with linear sample
+// interpolation.
+//
+// Here the chip is clocked every cycle. This yields higher quality
+// sound since the samples are linearly interpolated, and since the
+// external filter attenuates frequencies above 16kHz, thus reducing
+// sampling noise.
+// ----------------------------------------------------------------------------
+func (s *Sid) clockInterpolate(delta_t *CycleCount, buf []int16, n int, interleave int) int {
+	i := 0
+	var c CycleCount
+
+	for {
+		next_sample_offset := s.sampleOffset + s.cyclesPerSample
+		delta_t_sample := next_sample_offset >> FIXP_SHIFT
+		if delta_t_sample > *delta_t {
+			break
+		}
+		if i >= n {
+			return i
+		}
+		for c = 0; c < delta_t_sample-1; c++ {
+			s.Clock(1)
+		}
+		if c < delta_t_sample {
+			s.samplePrev = int16(s.Output())
+			s.Clock(1)
+		}
+
+		*delta_t -= delta_t_sample
+		s.sampleOffset = next_sample_offset & FIXP_MASK
+
+		sample_now := int16(s.Output())
+		buf[i*interleave] = interpolateSample(s.samplePrev, sample_now, s.sampleOffset)
+		if s.tapBuf != nil {
+			s.tapBuf[i] = s.Taps()
+		}
+		i++
+		s.samplePrev = sample_now
+	}
+
+	for c = 0; c < *delta_t-1; c++ {
+		s.Clock(1)
+	}
+	if c < *delta_t {
+		s.samplePrev = int16(s.Output())
+		s.Clock(1)
+	}
+	s.sampleOffset -= *delta_t << FIXP_SHIFT
+	*delta_t = 0
+	return i
+}
+
+// interpolateSample returns the sample at the fixpoint fraction offset of
+// the way from prev to now. The difference is taken in int, as it does not
+// fit in int16 for swings of more than half the range.
+func interpolateSample(prev int16, now int16, offset CycleCount) int16 {
+	return prev + int16(int(offset)*(int(now)-int(prev))>>FIXP_SHIFT)
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking with audio sampling - cycle based with audio resampling.
+//
+// This is the theoretically correct (and computationally intensive) audio
+// sample generation. The samples are generated by resampling to the specified
+// sampling frequency. The work rate is inversely proportional to the
+// percentage of the bandwidth allocated to the filter transition band.
+//
+// This implementation is based on the paper "A Flexible Sampling-Rate
+// Conversion Method", by J. O. Smith and P. Gosset, or rather on the
+// expanded tutorial on the "Digital Audio Resampling Home Page":
+// http://www-ccrma.stanford.edu/~jos/resample/
+//
+// By building shifted FIR tables with samples according to the
+// sampling frequency, this implementation dramatically reduces the
+// computational effort in the filter convolutions, without any loss
+// of accuracy. The filter convolutions are also vectorizable on
+// current hardware.
+//
+// Further possible optimizations are:
+//   - An equiripple filter design could yield a lower filter order, see
+//     http://www.mwrf.com/Articles/ArticleID/7229/7229.html
+//   - The Convolution Theorem could be used to bring the complexity of
+//     convolution down from O(n*n) to O(n*log(n)) using the Fast Fourier
+//     Transform, see http://en.wikipedia.org/wiki/Convolution_theorem
+//   - Simply resampling in two steps can also yield computational
+//     savings, since the transition band will be wider in the first step
+//     and the required filter order is thus lower in this step.
+//     Laurent Ganier has found the optimal intermediate sampling frequency
+//     to be (via derivation of sum of two steps):
+//     2 * pass_freq + sqrt [ 2 * pass_freq * orig_sample_freq
+//   - (dest_sample_freq - 2 * pass_freq) / dest_sample_freq ]
+//
+// ----------------------------------------------------------------------------
+func (s *Sid) clockResampleInterpolate(delta_t *CycleCount, buf []int16, n int, interleave int) int {
+	i := 0
+
+	for {
+		next_sample_offset := s.sampleOffset + s.cyclesPerSample
+		delta_t_sample := next_sample_offset >> FIXP_SHIFT
+		if delta_t_sample > *delta_t {
+			break
+		}
+		if i >= n {
+			return i
+		}
+		s.clockRing(delta_t_sample)
+		*delta_t -= delta_t_sample
+		s.sampleOffset = next_sample_offset & FIXP_MASK
+
+		fir_offset := int(s.sampleOffset) * s.firRES >> FIXP_SHIFT
+		fir_offset_rmd := int(s.sampleOffset) * s.firRES & FIXP_MASK
+		sample_start := s.sampleIndex - s.firN + RINGSIZE
+
+		// Convolution with filter impulse response.
+		v1 := s.convolve(sample_start, fir_offset)
+
+		// Use next FIR table, wrap around to first FIR table using
+		// previous sample.
+		fir_offset++
+		if fir_offset == s.firRES {
+			fir_offset = 0
+			sample_start--
+		}
+
+		// Convolution with filter impulse response.
+		v2 := s.convolve(sample_start, fir_offset)
+
+		// Linear interpolation.
+		// fir_offset_rmd is equal for all samples, it can thus be factorized out:
+		// sum(v1 + rmd*(v2 - v1)) = sum(v1) + rmd*(sum(v2) - sum(v1))
+		v := v1 + (fir_offset_rmd * (v2 - v1) >> FIXP_SHIFT)
+
+		buf[i*interleave] = saturate16(v >> FIR_SHIFT)
+		if s.tapBuf != nil {
+			s.tapBuf[i] = s.resampledTaps()
+		}
+		i++
+	}
+
+	s.clockRing(*delta_t)
+	s.sampleOffset -= *delta_t << FIXP_SHIFT
+	*delta_t = 0
+	return i
+}
+
+// ----------------------------------------------------------------------------
+// SID clocking with audio sampling - cycle based with audio resampling.
+// ----------------------------------------------------------------------------
+func (s *Sid) clockResampleFast(delta_t *CycleCount, buf []int16, n int, interleave int) int {
+	i := 0
+
+	for {
+		next_sample_offset := s.sampleOffset + s.cyclesPerSample
+		delta_t_sample := next_sample_offset >> FIXP_SHIFT
+		if delta_t_sample > *delta_t {
+			break
+		}
+		if i >= n {
+			return i
+		}
+		s.clockRing(delta_t_sample)
+		*delta_t -= delta_t_sample
+		s.sampleOffset = next_sample_offset & FIXP_MASK
+
+		fir_offset := int(s.sampleOffset) * s.firRES >> FIXP_SHIFT
+		sample_start := s.sampleIndex - s.firN + RINGSIZE
+
+		// Convolution with filter impulse response.
+		v := s.convolve(sample_start, fir_offset)
+
+		buf[i*interleave] = saturate16(v >> FIR_SHIFT)
+		if s.tapBuf != nil {
+			s.tapBuf[i] = s.resampledTaps()
+		}
+		i++
+	}
+
+	s.clockRing(*delta_t)
+	s.sampleOffset -= *delta_t << FIXP_SHIFT
+	*delta_t = 0
+	return i
+}
+
+// Clock the chip cycle by cycle, storing each output sample in the ring
+// buffer used by the resampling FIR filter.
+func (s *Sid) clockRing(delta_t CycleCount) {
+	for c := CycleCount(0); c < delta_t; c++ {
+		s.Clock(1)
+		out := int16(s.Output())
+		s.sample[s.sampleIndex] = out
+		s.sample[s.sampleIndex+RINGSIZE] = out
+		if s.taps != nil {
+			s.taps.ring[s.sampleIndex] = s.Taps()
+		}
+		s.sampleIndex++
+		s.sampleIndex &= 0x3fff
+	}
+}
+
+// Convolution of the ring buffer samples with FIR table fir_offset.
+func (s *Sid) convolve(sample_start int, fir_offset int) int {
+	samples := s.sample[sample_start : sample_start+s.firN]
+	fir := s.fir[fir_offset*s.firN : (fir_offset+1)*s.firN]
+
+	v := 0
+	for j := range fir {
+		v += int(samples[j]) * int(fir[j])
+	}
+	return v
+}
+
+// Saturated arithmetics to guard against 16 bit sample overflow.
+func saturate16(v int) int16 {
+	half := 1 << 15
+	if v >= half {
+		return int16(half - 1)
+	}
+	if v < -half {
+		return int16(-half)
+	}
+	return int16(v)
+}
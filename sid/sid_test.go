@@ -0,0 +1,71 @@
+package resid
+
+import "testing"
+
+// Reading a write only register returns the last value written, with the
+// bits fading to zero. None fades within the first half of the life time,
+// and all are gone after $1d00 cycles on the MOS6581 and $a2000 cycles on
+// the MOS8580.
+func TestReadBusValueFade(t *testing.T) {
+	for _, test := range []struct {
+		model Model
+		ttl   CycleCount
+	}{
+		{MOS6581, 0x1d00},
+		{MOS8580, 0xa2000},
+	} {
+		s := NewSID()
+		s.SetModel(test.model)
+		s.Write(0x05, 0xff)
+		s.Clock(test.ttl/2 - 1)
+		if got := s.Read(0x00); got != 0xff {
+			t.Fatalf("model %d: bus value = %#02x after %#x cycles, want 0xff", test.model, got, test.ttl/2-1)
+		}
+
+		prev := s.Read(0x00)
+		seen := map[uint8]bool{}
+		for age := test.ttl / 2; age < test.ttl; age += test.ttl / 64 {
+			s.Clock(test.ttl / 64)
+			got := s.Read(0x00)
+			if got&^prev != 0 {
+				t.Fatalf("model %d: bus value bits rose from %#02x to %#02x", test.model, prev, got)
+			}
+			seen[got] = true
+			prev = got
+		}
+		if len(seen) < 4 {
+			t.Errorf("model %d: bus value faded in %d steps, want the bits one by one", test.model, len(seen))
+		}
+		s.Clock(test.ttl / 2)
+		if got := s.Read(0x1e); got != 0x00 {
+			t.Fatalf("model %d: bus value = %#02x after the life time, want 0", test.model, got)
+		}
+
+		// A write restores the full value, from any register.
+		s.Write(0x18, 0x5a)
+		if got := s.Read(0x00); got != 0x5a {
+			t.Fatalf("model %d: bus value = %#02x after write, want 0x5a", test.model, got)
+		}
+	}
+}
+
+// The audio input has room for a 16-bit signal on top of a full scale DC
+// level, and clips beyond that.
+func TestInputRange(t *testing.T) {
+	s := NewSID()
+	for _, test := range []struct {
+		sample, want sound_sample
+	}{
+		{0, 0},
+		{32767, 32767},
+		{-32768 - 32767, -32768 - 32767},
+		{-0x10000, -0x10000},
+		{-0x20000, -0x10000},
+		{0x20000, 0xffff},
+	} {
+		s.Input(test.sample)
+		if got := s.extIn / (3 << 4); got != test.want {
+			t.Errorf("input %d: %d, want %d", test.sample, got, test.want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package resid
 // Model selects the SID chip: 6581 or 8580
 type Model byte
 type SamplingMethod byte
+type FilterType byte
 
 type reg4 uint8
 type reg8 uint8
@@ -26,4 +27,29 @@ const (
 
 	// Interpolate
 	SAMPLE_INTERPOLATE
-)
\ No newline at end of file
+
+	// Resample with a FIR filter, interpolating between FIR tables
+	SAMPLE_RESAMPLE_INTERPOLATE
+
+	// Resample with a FIR filter, nearest FIR table
+	SAMPLE_RESAMPLE_FAST
+)
+
+const (
+	// Linear state-variable filter of reSID 0.16
+	FILTER_LINEAR FilterType = iota
+
+	// Non-linear two-integrator-loop filter
+	FILTER_FP
+)
+
+// OutputClip selects how the output is limited to 16 bits.
+type OutputClip byte
+
+const (
+	// Hard clipping at full scale
+	CLIP_HARD OutputClip = iota
+
+	// Soft clipping, compressing the top quarter of full scale
+	CLIP_SOFT
+)
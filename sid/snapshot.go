@@ -0,0 +1,256 @@
+package resid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Snapshot format, a tag and a version followed by the chip state in little
+// endian byte order. The version is raised whenever the layout changes.
+const (
+	SNAPSHOT_TAG     = "RSID"
+	SNAPSHOT_VERSION = 2
+)
+
+// stateWriter writes fixed-size values, keeping the first error.
+type stateWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (sw *stateWriter) write(values ...any) {
+	for _, v := range values {
+		if sw.err != nil {
+			return
+		}
+		sw.err = binary.Write(sw.w, binary.LittleEndian, v)
+	}
+}
+
+// stateReader reads fixed-size values, keeping the first error.
+type stateReader struct {
+	r   io.Reader
+	err error
+}
+
+func (sr *stateReader) read(values ...any) {
+	for _, v := range values {
+		if sr.err != nil {
+			return
+		}
+		sr.err = binary.Read(sr.r, binary.LittleEndian, v)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Save chip state.
+// The snapshot holds the registers and every internal of the voices, the
+// filter and the external filter, so that a chip loading it continues
+// bit-identically. The configuration - chip model, filter implementation,
+// DAC model, tables and sampling parameters - is not part of the snapshot
+// and must be set up the same way before loading.
+// ----------------------------------------------------------------------------
+func (s *Sid) SaveState(w io.Writer) error {
+	sw := &stateWriter{w: w}
+	sw.write([]byte(SNAPSHOT_TAG), uint16(SNAPSHOT_VERSION))
+	sw.write(uint8(s.model), uint8(s.filterType), s.filterRegs)
+
+	for _, v := range s.voice {
+		v.saveState(sw)
+	}
+	s.filter.saveState(sw)
+	s.extfilter.saveState(sw)
+
+	sw.write(uint8(s.potx), uint8(s.poty), uint8(s.potxIn), uint8(s.potyIn), int64(s.potCycles))
+	sw.write(uint8(s.busValue), int64(s.busValueAge), int64(s.extIn))
+	sw.write(int64(s.sampleOffset), s.samplePrev, int64(s.sampleIndex))
+	sw.write(uint32(len(s.sample)), s.sample)
+	return sw.err
+}
+
+// ----------------------------------------------------------------------------
+// Load chip state saved by SaveState.
+// The voice taps start from zero, see EnableTaps. On a read error the chip
+// is left as it was.
+// ----------------------------------------------------------------------------
+func (s *Sid) LoadState(r io.Reader) error {
+	// The state is read in place, keep the current one to go back to.
+	var prev bytes.Buffer
+	if err := s.SaveState(&prev); err != nil {
+		return err
+	}
+	if err := s.loadState(r); err != nil {
+		s.loadState(&prev)
+		return err
+	}
+	return nil
+}
+
+func (s *Sid) loadState(r io.Reader) error {
+	sr := &stateReader{r: r}
+	var tag [4]byte
+	var version uint16
+	sr.read(&tag, &version)
+	if sr.err != nil {
+		return sr.err
+	}
+	if string(tag[:]) != SNAPSHOT_TAG {
+		return errors.New("not a SID snapshot")
+	}
+	if version != SNAPSHOT_VERSION {
+		return fmt.Errorf("unsupported SID snapshot version %d", version)
+	}
+
+	var model, filterType uint8
+	sr.read(&model, &filterType, &s.filterRegs)
+	if sr.err != nil {
+		return sr.err
+	}
+	if Model(model) != s.model || FilterType(filterType) != s.filterType {
+		return errors.New("SID snapshot of a different chip model or filter")
+	}
+
+	for _, v := range s.voice {
+		v.loadState(sr)
+	}
+	s.filter.loadState(sr)
+	s.extfilter.loadState(sr)
+
+	var potx, poty, potxIn, potyIn, busValue uint8
+	var potCycles, busValueAge, extIn, sampleOffset, sampleIndex int64
+	var sampleLen uint32
+	sr.read(&potx, &poty, &potxIn, &potyIn, &potCycles)
+	sr.read(&busValue, &busValueAge, &extIn)
+	sr.read(&sampleOffset, &s.samplePrev, &sampleIndex, &sampleLen)
+	if sr.err != nil {
+		return sr.err
+	}
+	if int(sampleLen) != len(s.sample) {
+		return errors.New("SID snapshot of a different sampling method")
+	}
+	sr.read(s.sample)
+	if sr.err != nil {
+		return sr.err
+	}
+
+	s.potx, s.poty = reg8(potx), reg8(poty)
+	s.potxIn, s.potyIn = reg8(potxIn), reg8(potyIn)
+	s.potCycles = CycleCount(potCycles)
+	s.busValue = reg8(busValue)
+	s.busValueAge = CycleCount(busValueAge)
+	s.extIn = sound_sample(extIn)
+	s.sampleOffset = CycleCount(sampleOffset)
+	s.sampleIndex = int(sampleIndex)
+	s.resetTaps()
+	return nil
+}
+
+func (v *Voice) saveState(sw *stateWriter) {
+	sw.write(v.muted)
+	v.Wave.saveState(sw)
+	v.Envelope.saveState(sw)
+}
+
+func (v *Voice) loadState(sr *stateReader) {
+	sr.read(&v.muted)
+	v.Wave.loadState(sr)
+	v.Envelope.loadState(sr)
+}
+
+func (w *WaveformGenerator) saveState(sw *stateWriter) {
+	sw.write(uint32(w.accumulator), uint32(w.shiftreg), int64(w.shiftregAge))
+	sw.write(uint16(w.freq), uint16(w.pw), uint16(w.pulseOutput), uint16(w.pulseCompare), uint16(w.outputDelayed))
+	sw.write(uint8(w.waveform), uint8(w.test), uint8(w.ringmod), uint8(w.sync), w.msbRising)
+}
+
+func (w *WaveformGenerator) loadState(sr *stateReader) {
+	var accumulator, shiftreg uint32
+	var shiftregAge int64
+	var freq, pw, pulseOutput, pulseCompare, outputDelayed uint16
+	var waveform, test, ringmod, sync uint8
+	sr.read(&accumulator, &shiftreg, &shiftregAge)
+	sr.read(&freq, &pw, &pulseOutput, &pulseCompare, &outputDelayed)
+	sr.read(&waveform, &test, &ringmod, &sync, &w.msbRising)
+
+	w.accumulator, w.shiftreg = reg24(accumulator), reg24(shiftreg)
+	w.shiftregAge = CycleCount(shiftregAge)
+	w.freq, w.pw = reg16(freq), reg12(pw)
+	w.pulseOutput, w.pulseCompare, w.outputDelayed = reg12(pulseOutput), reg12(pulseCompare), reg12(outputDelayed)
+	w.waveform, w.test, w.ringmod, w.sync = reg8(waveform), reg8(test), reg8(ringmod), reg8(sync)
+}
+
+func (e *EnvelopeGenerator) saveState(sw *stateWriter) {
+	sw.write(uint16(e.rate_counter), uint16(e.rate_period))
+	sw.write(int32(e.exponential_counter), int32(e.exponential_counter_period), int32(e.envelope_counter))
+	sw.write(uint8(e.attack), uint8(e.decay), uint8(e.sustain), uint8(e.release), uint8(e.gate))
+	sw.write(uint8(e.state), uint8(e.nextState), e.holdZero, e.resetRateCounter)
+	sw.write(uint8(e.statePipeline), uint8(e.envelopePipeline), uint8(e.exponentialPipeline))
+}
+
+func (e *EnvelopeGenerator) loadState(sr *stateReader) {
+	var rateCounter, ratePeriod uint16
+	var exponentialCounter, exponentialCounterPeriod, envelopeCounter int32
+	var attack, decay, sustain, release, gate, state, nextState uint8
+	var statePipeline, envelopePipeline, exponentialPipeline uint8
+	sr.read(&rateCounter, &ratePeriod)
+	sr.read(&exponentialCounter, &exponentialCounterPeriod, &envelopeCounter)
+	sr.read(&attack, &decay, &sustain, &release, &gate)
+	sr.read(&state, &nextState, &e.holdZero, &e.resetRateCounter)
+	sr.read(&statePipeline, &envelopePipeline, &exponentialPipeline)
+
+	e.rate_counter, e.rate_period = reg16(rateCounter), reg16(ratePeriod)
+	e.exponential_counter = int(exponentialCounter)
+	e.exponential_counter_period = int(exponentialCounterPeriod)
+	e.envelope_counter = int(envelopeCounter)
+	e.attack, e.decay, e.sustain, e.release = reg4(attack), reg4(decay), reg4(sustain), reg4(release)
+	e.gate = reg8(gate)
+	e.state, e.nextState = State(state), State(nextState)
+	e.statePipeline = int(statePipeline)
+	e.envelopePipeline = int(envelopePipeline)
+	e.exponentialPipeline = int(exponentialPipeline)
+}
+
+func (f *SidFilter) saveState(sw *stateWriter) {
+	sw.write(uint16(f.Fc), uint8(f.Res), uint8(f.Filter), uint8(f.Voice3Off), uint8(f.HpBpLp), uint8(f.Volume))
+	sw.write(int64(f.Vhp), int64(f.Vbp), int64(f.Vlp), int64(f.Vnf))
+}
+
+func (f *SidFilter) loadState(sr *stateReader) {
+	var fc uint16
+	var res, filter, voice3Off, hpBpLp, volume uint8
+	var vhp, vbp, vlp, vnf int64
+	sr.read(&fc, &res, &filter, &voice3Off, &hpBpLp, &volume)
+	sr.read(&vhp, &vbp, &vlp, &vnf)
+	if sr.err != nil {
+		return
+	}
+
+	f.Fc, f.Res, f.Filter = reg12(fc)&0x7ff, reg8(res), reg8(filter)
+	f.Voice3Off, f.HpBpLp, f.Volume = reg8(voice3Off), reg8(hpBpLp), reg4(volume)
+	f.Vhp, f.Vbp, f.Vlp, f.Vnf = sound_sample(vhp), sound_sample(vbp), sound_sample(vlp), sound_sample(vnf)
+	f.SetW0()
+	f.SetQ()
+}
+
+func (f *FilterFP) saveState(sw *stateWriter) {
+	f.SidFilter.saveState(sw)
+	sw.write(f.vhp, f.vbp, f.vlp)
+}
+
+func (f *FilterFP) loadState(sr *stateReader) {
+	f.SidFilter.loadState(sr)
+	sr.read(&f.vhp, &f.vbp, &f.vlp)
+}
+
+func (e *ExternalFilter) saveState(sw *stateWriter) {
+	sw.write(int64(e.Vlp), int64(e.Vhp), int64(e.Vo))
+}
+
+func (e *ExternalFilter) loadState(sr *stateReader) {
+	var vlp, vhp, vo int64
+	sr.read(&vlp, &vhp, &vo)
+	e.Vlp, e.Vhp, e.Vo = sound_sample(vlp), sound_sample(vhp), sound_sample(vo)
+}
@@ -0,0 +1,116 @@
+package resid
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A chip playing a filtered pulse and a noise voice with a sweeping cutoff.
+func newTestSid(model Model, filterType FilterType, method SamplingMethod) *Sid {
+	s := NewSID()
+	s.SetModel(model)
+	s.SetFilterType(filterType)
+	s.SetSamplingParameters(985248, method, 44100)
+	for reg, value := range map[uint8]uint8{
+		0x00: 0x35, 0x01: 0x1c, 0x02: 0x00, 0x03: 0x06, 0x05: 0x09, 0x06: 0xa8,
+		0x07: 0x10, 0x08: 0x40, 0x0c: 0x22, 0x0d: 0xf4,
+		0x16: 0x40, 0x17: 0xf3, 0x18: 0x1f,
+	} {
+		s.Write(reg, value)
+	}
+	s.Write(0x04, 0x41)
+	s.Write(0x0b, 0x81)
+	return s
+}
+
+func renderTestSid(s *Sid, frames int) []int16 {
+	var out []int16
+	buf := make([]int16, 1000)
+	for frame := 0; frame < frames; frame++ {
+		s.Write(0x16, uint8(frame*7))
+		if frame == frames/2 {
+			s.Write(0x04, 0x40)
+		}
+		delta_t := CycleCount(19656)
+		for delta_t > 0 {
+			n := s.ClockBuffer(&delta_t, buf)
+			out = append(out, buf[:n]...)
+		}
+	}
+	return out
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	for _, model := range []Model{MOS6581, MOS8580} {
+		for _, filterType := range []FilterType{FILTER_LINEAR, FILTER_FP} {
+			for _, method := range []SamplingMethod{SAMPLE_FAST, SAMPLE_RESAMPLE_INTERPOLATE} {
+				s := newTestSid(model, filterType, method)
+				renderTestSid(s, 20)
+
+				var snapshot bytes.Buffer
+				if err := s.SaveState(&snapshot); err != nil {
+					t.Fatal(err)
+				}
+				want := renderTestSid(s, 20)
+
+				restored := newTestSid(model, filterType, method)
+				if err := restored.LoadState(&snapshot); err != nil {
+					t.Fatal(err)
+				}
+				got := renderTestSid(restored, 20)
+
+				if !bytes.Equal(int16Bytes(got), int16Bytes(want)) {
+					t.Errorf("model %d, filter %d, sampling %d: restored chip output differs", model, filterType, method)
+				}
+			}
+		}
+	}
+}
+
+func TestSnapshotConfiguration(t *testing.T) {
+	s := newTestSid(MOS6581, FILTER_LINEAR, SAMPLE_FAST)
+	var snapshot bytes.Buffer
+	if err := s.SaveState(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newTestSid(MOS8580, FILTER_LINEAR, SAMPLE_FAST)
+	if err := other.LoadState(bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Error("snapshot loaded into a different chip model")
+	}
+	other = newTestSid(MOS6581, FILTER_LINEAR, SAMPLE_RESAMPLE_FAST)
+	if err := other.LoadState(bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Error("snapshot loaded with a different sampling method")
+	}
+}
+
+func TestSnapshotTruncated(t *testing.T) {
+	s := newTestSid(MOS6581, FILTER_FP, SAMPLE_RESAMPLE_INTERPOLATE)
+	renderTestSid(s, 20)
+	var snapshot bytes.Buffer
+	if err := s.SaveState(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed load leaves the chip as it was.
+	for _, n := range []int{100, snapshot.Len() / 2, snapshot.Len() - 1} {
+		want := newTestSid(MOS6581, FILTER_FP, SAMPLE_RESAMPLE_INTERPOLATE)
+		renderTestSid(want, 3)
+		got := newTestSid(MOS6581, FILTER_FP, SAMPLE_RESAMPLE_INTERPOLATE)
+		renderTestSid(got, 3)
+		if err := got.LoadState(bytes.NewReader(snapshot.Bytes()[:n])); err == nil {
+			t.Fatalf("%d bytes: truncated snapshot loaded", n)
+		}
+		if !bytes.Equal(int16Bytes(renderTestSid(got, 5)), int16Bytes(renderTestSid(want, 5))) {
+			t.Errorf("%d bytes: chip changed by a failed load", n)
+		}
+	}
+}
+
+func int16Bytes(samples []int16) []byte {
+	b := make([]byte, 0, 2*len(samples))
+	for _, v := range samples {
+		b = append(b, byte(v), byte(v>>8))
+	}
+	return b
+}
@@ -0,0 +1,109 @@
+package resid
+
+// VoiceState is a snapshot of the registers and the oscillator and envelope
+// state of a voice.
+type VoiceState struct {
+	Frequency   uint16
+	PulseWidth  uint16
+	Accumulator uint32
+
+	// Waveform selection, upper 4 bits of the control register: 0x1
+	// triangle, 0x2 sawtooth, 0x4 pulse, 0x8 noise.
+	Waveform uint8
+	Test     bool
+	Ring     bool
+	Sync     bool
+	Gate     bool
+
+	// Waveform generator output, 12 bits.
+	Output uint16
+
+	Attack, Decay, Sustain, Release uint8
+	EnvelopeState                   State
+	EnvelopeLevel                   uint8
+}
+
+// FilterState is a snapshot of the filter registers.
+type FilterState struct {
+	// Cutoff register, 11 bits, and the cutoff frequency in Hz it gives on
+	// the chip model.
+	Cutoff     uint16
+	CutoffFreq int
+
+	Resonance uint8
+
+	// Voices routed through the filter, bit 0-2 for voice 1-3 and bit 3
+	// for EXT IN.
+	Routing uint8
+
+	// Filter modes, bit 0 low-pass, bit 1 band-pass, bit 2 high-pass.
+	Mode      uint8
+	Voice3Off bool
+	Volume    uint8
+}
+
+// SidState is a snapshot of the voices and the filter.
+type SidState struct {
+	Voice  [3]VoiceState
+	Filter FilterState
+}
+
+func (s State) String() string {
+	switch s {
+	case ATTACK:
+		return "attack"
+	case DECAY_SUSTAIN:
+		return "decay/sustain"
+	case RELEASE:
+		return "release"
+	}
+	return "unknown"
+}
+
+// ----------------------------------------------------------------------------
+// Read snapshot of the voice and filter state.
+// The snapshot is a value without references into the chip, and is taken
+// without allocating, so that it can be read every frame.
+// ----------------------------------------------------------------------------
+func (s *Sid) State() SidState {
+	var state SidState
+	for i, v := range s.voice {
+		state.Voice[i] = v.State()
+	}
+	state.Filter = s.filter.State()
+	return state
+}
+
+func (v *Voice) State() VoiceState {
+	w := v.Wave
+	e := v.Envelope
+	return VoiceState{
+		Frequency:     uint16(w.freq),
+		PulseWidth:    uint16(w.pw),
+		Accumulator:   uint32(w.accumulator),
+		Waveform:      uint8(w.waveform),
+		Test:          w.test != 0,
+		Ring:          w.ringmod != 0,
+		Sync:          w.sync != 0,
+		Gate:          e.gate != 0,
+		Output:        uint16(w.Output()),
+		Attack:        uint8(e.attack),
+		Decay:         uint8(e.decay),
+		Sustain:       uint8(e.sustain),
+		Release:       uint8(e.release),
+		EnvelopeState: e.state,
+		EnvelopeLevel: uint8(e.envelope_counter),
+	}
+}
+
+func (f *SidFilter) State() FilterState {
+	return FilterState{
+		Cutoff:     uint16(f.Fc),
+		CutoffFreq: int((*f.F0)[f.fcDAC[f.Fc]]),
+		Resonance:  uint8(f.Res),
+		Routing:    uint8(f.Filter),
+		Mode:       uint8(f.HpBpLp),
+		Voice3Off:  f.Voice3Off != 0,
+		Volume:     uint8(f.Volume),
+	}
+}
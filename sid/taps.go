@@ -0,0 +1,103 @@
+package resid
+
+// VoiceTap is the output of a single voice at one sample, for stems and
+// per-voice scopes.
+type VoiceTap struct {
+	// Voice output after the envelope, before the filter and the master
+	// volume, without the voice DC offset of the MOS6581.
+	Voice int16
+
+	// Contribution of the voice to the audio output: through the filter when
+	// routed there, or past it, and through the master volume and the
+	// external filter.
+	Mix int16
+}
+
+// voiceTaps keeps a copy of the filter and the external filter for each
+// voice, fed only by that voice. The filter registers are written to all
+// copies.
+type voiceTaps struct {
+	filter    [3]Filter
+	extfilter [3]ExternalFilter
+
+	// Taps of each cycle, alongside the sample ring buffer of the
+	// resampling sampling methods.
+	ring [][3]VoiceTap
+}
+
+// ----------------------------------------------------------------------------
+// Enable voice taps.
+// The contribution of each voice to the output is found by running the
+// voice through its own copy of the filter and the external filter, which
+// roughly doubles the time spent in the filters. The copies start from zero
+// each time the chip model, DAC model, filter or sampling parameters change.
+// ----------------------------------------------------------------------------
+func (s *Sid) EnableTaps(enable bool) {
+	if !enable {
+		s.taps = nil
+		return
+	}
+	s.taps = &voiceTaps{ring: make([][3]VoiceTap, RINGSIZE)}
+	s.resetTaps()
+}
+
+// resetTaps sets up the filter copies of the voice taps from the filters in
+// use.
+func (s *Sid) resetTaps() {
+	if s.taps == nil {
+		return
+	}
+	for i := range s.taps.filter {
+		s.taps.filter[i] = s.newFilter()
+		s.taps.extfilter[i] = *s.extfilter
+		s.taps.extfilter[i].Reset()
+	}
+}
+
+// tapFilters returns the filter copies of the voice taps, nil if disabled.
+func (s *Sid) tapFilters() []Filter {
+	if s.taps == nil {
+		return nil
+	}
+	return s.taps.filter[:]
+}
+
+func (t *voiceTaps) clock(delta_t CycleCount, voice1 sound_sample, voice2 sound_sample, voice3 sound_sample) {
+	t.filter[0].Clock(delta_t, voice1, 0, 0, 0)
+	t.filter[1].Clock(delta_t, 0, voice2, 0, 0)
+	t.filter[2].Clock(delta_t, 0, 0, voice3, 0)
+
+	for i := range t.extfilter {
+		t.extfilter[i].Clock(delta_t, t.filter[i].Output())
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Read voice taps.
+// The taps are read at the current cycle. The sampling methods store them at
+// each sample, picking the nearest cycle instead of interpolating or
+// resampling. Mix is 0 unless taps are enabled.
+// On the MOS6581 the voice and mixer DC offsets are part of the Mix of each
+// voice, so the taps do not add up to the output.
+// ----------------------------------------------------------------------------
+func (s *Sid) Taps() [3]VoiceTap {
+	var taps [3]VoiceTap
+	for i, v := range s.voice {
+		// Voice outputs are 20 bits, with the MOS6581 waveform DC offset
+		// on top.
+		taps[i].Voice = saturate16(int((v.Output() - v.voiceDC) >> 5))
+		if s.taps != nil {
+			taps[i].Mix = int16(s.scaleOutput(s.taps.extfilter[i].Output()))
+		}
+	}
+	return taps
+}
+
+// resampledTaps returns the taps at the center of the FIR filter of the
+// resampling sampling methods, in step with the resampled output.
+func (s *Sid) resampledTaps() [3]VoiceTap {
+	if s.taps == nil {
+		return s.Taps()
+	}
+	return s.taps.ring[(s.sampleIndex-s.firN/2-1)&(RINGSIZE-1)]
+}
@@ -6,6 +6,10 @@ type Voice struct {
 	muted    bool
 	waveZero sound_sample
 	voiceDC  sound_sample
+
+	// Waveform and envelope D/A converter lookup tables.
+	waveDAC []uint16
+	envDAC  []uint16
 }
 
 // ----------------------------------------------------------------------------
@@ -18,6 +22,7 @@ func NewVoice() *Voice {
 	v.Wave = NewWaveformGenerator()
 	v.Envelope = NewEnvelopeGenerator()
 	v.SetModel(MOS6581)
+	v.SetDACModel(DAC_IDEAL)
 	return v
 }
 
@@ -96,6 +101,14 @@ func (v *Voice) SetModel(model Model) {
 	}
 }
 
+// ----------------------------------------------------------------------------
+// Set D/A converter model for the waveform and envelope DACs.
+// ----------------------------------------------------------------------------
+func (v *Voice) SetDACModel(model DacModel) {
+	v.waveDAC = buildDacTable(12, model)
+	v.envDAC = buildDacTable(8, model)
+}
+
 // ----------------------------------------------------------------------------
 // Set sync source.
 // ----------------------------------------------------------------------------
@@ -118,8 +131,8 @@ func (v *Voice) WriteCONTROL_REG(control reg8) {
 func (v *Voice) Output() sound_sample {
 	var outp sound_sample
 	if !v.muted { // Multiply oscillator output with envelope output.
-		outp = sound_sample(v.Wave.Output()) - v.waveZero
-		outp *= sound_sample(v.Envelope.Output())
+		outp = sound_sample(v.waveDAC[v.Wave.Output()]) - v.waveZero
+		outp *= sound_sample(v.envDAC[v.Envelope.Output()])
 		outp += (v.voiceDC)
 		return outp
 	}
@@ -2,17 +2,19 @@ package resid
 
 // Voice represents a voice in the SID chip.
 type WaveformGenerator struct {
-	syncDest    *WaveformGenerator
-	syncSource  *WaveformGenerator
-	msbRising   bool
-	accumulator reg24
-	shiftreg    reg24
-	freq        reg16
-	pw          reg12
-	waveform    reg8
-	test        reg8
-	ringmod     reg8
-	sync        reg8
+	syncDest     *WaveformGenerator
+	syncSource   *WaveformGenerator
+	msbRising    bool
+	accumulator  reg24
+	shiftreg     reg24
+	freq         reg16
+	pw           reg12
+	pulseOutput  reg12
+	pulseCompare reg12
+	waveform     reg8
+	test         reg8
+	ringmod      reg8
+	sync         reg8
 
 	wave__ST *[]reg8
 	wave_P_T *[]reg8
@@ -20,6 +22,18 @@ type WaveformGenerator struct {
 	wave_PST *[]reg8
 
 	model Model
+
+	// Cycles since the test bit was set, and life time of each shift
+	// register bit while the test bit is held, before it is set to one.
+	shiftregAge  CycleCount
+	shiftregFade []CycleCount
+
+	// Combined waveform tables overriding the chip model default, nil if
+	// none.
+	waveTables *WaveTables
+
+	// Output of the previous cycle; the MOS8580 output is delayed one cycle.
+	outputDelayed reg12
 }
 
 // ----------------------------------------------------------------------------
@@ -39,8 +53,12 @@ func NewWaveformGenerator() *WaveformGenerator {
 func (w *WaveformGenerator) Reset() {
 	w.accumulator = 0
 	w.shiftreg = 0x7ffff8
+	w.shiftregAge = 0
 	w.freq = 0
 	w.pw = 0
+	w.pulseOutput = 0xfff
+	w.pulseCompare = 0xfff
+	w.outputDelayed = 0
 
 	w.test = 0
 	w.ringmod = 0
@@ -64,25 +82,104 @@ func (w *WaveformGenerator) SetModel(model Model) {
 	w.model = model
 
 	if w.model == MOS6581 {
+		w.shiftregFade = shiftregFade6581
 		w.wave_PS = &wave6581_PS_
 		w.wave_PST = &wave6581_PST
 		w.wave_P_T = &wave6581_P_T
 		w.wave__ST = &wave6581__ST
-		return
+	} else {
+		w.shiftregFade = shiftregFade8580
+		w.wave_PS = &wave8580_PS_
+		w.wave_PST = &wave8580_PST
+		w.wave_P_T = &wave8580_P_T
+		w.wave__ST = &wave8580__ST
+	}
+
+	if w.waveTables != nil {
+		w.wave_PS = &w.waveTables.PS
+		w.wave_PST = &w.waveTables.PST
+		w.wave_P_T = &w.waveTables.PT
+		w.wave__ST = &w.waveTables.ST
 	}
 
-	w.wave_PS = &wave8580_PS_
-	w.wave_PST = &wave8580_PST
-	w.wave_P_T = &wave8580_P_T
-	w.wave__ST = &wave8580__ST
+	w.outputDelayed = w.output()
 }
 
+// ----------------------------------------------------------------------------
+// Set combined waveform tables, or nil to use the tables of the chip model.
+// The tables are kept when the chip model changes. Incomplete tables are
+// rejected, keeping the tables in use.
+// ----------------------------------------------------------------------------
+func (w *WaveformGenerator) SetWaveTables(tables *WaveTables) error {
+	if tables != nil {
+		if err := tables.validate(); err != nil {
+			return err
+		}
+	}
+	w.waveTables = tables
+	w.SetModel(w.model)
+	return nil
+}
+
+// Life time in cycles of each shift register bit while the test bit is set.
+// reSID resets the shift register to 0x7fffff $8000 cycles after the test bit
+// is set on the MOS6581, and $950000 cycles after on the MOS8580, so the bits
+// rise towards one; after that time all bits are one here too. The order of
+// the bits within that time is synthetic, as for the bus value, see
+// busValueFade6581.
+var shiftregFade6581 = fadeTimes(0x8000, 23)
+var shiftregFade8580 = fadeTimes(0x950000, 23)
+
 // ----------------------------------------------------------------------------
 // SID clocking - delta_t cycles.
 // ----------------------------------------------------------------------------
 func (w *WaveformGenerator) Clock(delta_t CycleCount) {
-	// No operation if test bit is set.
+	if delta_t <= 0 {
+		return
+	}
+
+	// The output from the MOS8580 is delayed one cycle compared to the
+	// MOS6581. The output is latched before the last cycle; clocking in two
+	// parts yields the same state as clocking all cycles at once.
+	if w.model == MOS8580 {
+		msbRising := false
+		if delta_t > 1 {
+			w.clock(delta_t - 1)
+			msbRising = w.msbRising
+		}
+		w.outputDelayed = w.output()
+		w.clock(1)
+		w.msbRising = w.msbRising || msbRising
+		return
+	}
+
+	w.clock(delta_t)
+}
+
+func (w *WaveformGenerator) clock(delta_t CycleCount) {
+	// The accumulator is held at zero while the test bit is set, and the
+	// shift register bits fade towards one.
+	// The test bit holds the pulse output at 0xfff.
 	if w.test != 0 {
+		if w.shiftreg != 0x7fffff {
+			w.shiftregAge += delta_t
+			w.shiftreg |= 0x7fffff &^ reg24(fadeMask(w.shiftregFade, w.shiftregAge))
+		}
+		w.pulseOutput = 0xfff
+		w.pulseCompare = 0xfff
+		return
+	}
+
+	// Combined waveforms including noise write their output back into the
+	// shift register when it is clocked. The output is that of the cycle of
+	// the shift, so these are clocked one cycle at a time.
+	if w.waveform > 0x8 && delta_t > 1 {
+		msbRising := false
+		for ; delta_t > 0; delta_t-- {
+			w.clock(1)
+			msbRising = msbRising || w.msbRising
+		}
+		w.msbRising = msbRising
 		return
 	}
 
@@ -120,6 +217,9 @@ func (w *WaveformGenerator) Clock(delta_t CycleCount) {
 			}
 		}
 
+		// Combined waveforms including noise pull shift register bits low.
+		w.writeShiftRegister()
+
 		// Shift the noise/random register.
 		// NB! The shift is actually delayed 2 cycles, this is not modeled.
 		bit0 := ((w.shiftreg >> 22) ^ (w.shiftreg >> 17)) & 0x1
@@ -129,6 +229,15 @@ func (w *WaveformGenerator) Clock(delta_t CycleCount) {
 
 		delta_accumulator -= shift_period
 	}
+
+	// The result of the pulse width compare is delayed one cycle: the output
+	// is the compare of the cycle before the last one.
+	if delta_t > 1 {
+		w.pulseOutput = w.comparePulse((accumulator_prev + reg24(delta_t-1)*reg24(w.freq)) & 0xffffff)
+	} else {
+		w.pulseOutput = w.pulseCompare
+	}
+	w.pulseCompare = w.comparePulse(w.accumulator)
 }
 
 func (w *WaveformGenerator) Synchronize() {
@@ -144,7 +253,7 @@ func (w *WaveformGenerator) Synchronize() {
 // ----------------------------------------------------------------------------
 // Output functions.
 // NB! The output from SID 8580 is delayed one cycle compared to SID 6581,
-// this is not modeled.
+// see Clock and Output.
 // ----------------------------------------------------------------------------
 
 // No waveform:
@@ -183,21 +292,25 @@ func (w *WaveformGenerator) output__S_() reg12 { return reg12(w.accumulator >> 1
 // The upper 12 bits of the accumulator are used.
 // These bits are compared to the pulse width register by a 12 bit digital
 // comparator; output is either all one or all zero bits.
-// NB! The output is actually delayed one cycle after the compare.
-// This is not modeled.
+// The output is delayed one cycle after the compare, as in reSID 1.0, so a
+// change of the pulse width register is first seen on the output after two
+// cycles. The compare is done in Clock.
 //
 // The test bit, when set to one, holds the pulse waveform output at 0xfff
 // regardless of the pulse width setting.
 //
 
-func (w *WaveformGenerator) output_P__() reg12 {
-	if (w.test != 0) || ((w.accumulator >> 12) >= reg24(w.pw)) {
+func (w *WaveformGenerator) comparePulse(accumulator reg24) reg12 {
+	if accumulator>>12 >= reg24(w.pw) {
 		return 0xfff
 	}
-
 	return 0x000
 }
 
+func (w *WaveformGenerator) output_P__() reg12 {
+	return w.pulseOutput
+}
+
 // Noise:
 // The noise output is taken from intermediate bits of a 23-bit shift register
 // which is clocked by bit 19 of the accumulator.
@@ -312,17 +425,61 @@ func (w *WaveformGenerator) output_PST() reg12 {
 }
 
 // Combined waveforms including noise:
-// All waveform combinations including noise output zero after a few cycles.
-// NB! The effects of such combinations are not fully explored. It is claimed
-// that the shift register may be filled with zeroes and locked up, which
-// seems to be true.
-// We have not attempted to model this behavior, suffice to say that
-// there is very little audible output from waveform combinations including
-// noise. We hope that nobody is actually using it.
+// The noise output bits are short circuited with the bits of the other
+// selected waveforms just like for the other combined waveforms, so the
+// noise output is AND'ed with the output of the remaining waveforms.
+//
+// The short circuit also works the other way: the shift register bits
+// that feed the noise output are pulled low by zero bits in the combined
+// output. A bit once set to zero in this way stays zero, and the zeroes
+// are shifted on through the register and into the feedback, so the shift
+// register is slowly filled with zeroes and locked up. From then on there
+// is no output until the register is reset by the test bit.
+// The write-back is done each time the noise register is shifted and at
+// the end of each clocking, using the output at that point in time.
 //
 
 func (w *WaveformGenerator) outputNxxx() reg12 {
-	return 0
+	var wave reg12
+
+	switch w.waveform & 0x7 {
+	case 0x1:
+		wave = w.output___T()
+	case 0x2:
+		wave = w.output__S_()
+	case 0x3:
+		wave = w.output__ST()
+	case 0x4:
+		wave = w.output_P__()
+	case 0x5:
+		wave = w.output_P_T()
+	case 0x6:
+		wave = w.output_PS_()
+	case 0x7:
+		wave = w.output_PST()
+	}
+
+	return w.outputN___() & wave
+}
+
+// Write zero bits of a combined waveform including noise back into the
+// noise bits of the shift register; see outputN___ for the bit mapping.
+func (w *WaveformGenerator) writeShiftRegister() {
+	if w.waveform <= 0x8 || w.test != 0 {
+		return
+	}
+
+	output := reg24(w.outputNxxx())
+
+	w.shiftreg &= ^reg24((1<<22)|(1<<20)|(1<<16)|(1<<13)|(1<<11)|(1<<7)|(1<<4)|(1<<2)) |
+		((output & 0x800) << 11) |
+		((output & 0x400) << 10) |
+		((output & 0x200) << 7) |
+		((output & 0x100) << 5) |
+		((output & 0x080) << 4) |
+		((output & 0x040) << 1) |
+		((output & 0x020) >> 1) |
+		((output & 0x010) >> 2)
 }
 
 // ----------------------------------------------------------------------------
@@ -330,6 +487,13 @@ func (w *WaveformGenerator) outputNxxx() reg12 {
 // ----------------------------------------------------------------------------
 
 func (w *WaveformGenerator) Output() reg12 {
+	if w.model == MOS8580 {
+		return w.outputDelayed
+	}
+	return w.output()
+}
+
+func (w *WaveformGenerator) output() reg12 {
 	// It may seem cleaner to use an array of member functions to return
 	// waveform output; however a switch with inline functions is faster.
 
@@ -384,25 +548,27 @@ func (w *WaveformGenerator) WriteCONTROL_REG(control reg8) {
 	test_next := control & 0x08
 
 	// Test bit set.
-	// The accumulator and the shift register are both cleared.
-	// NB! The shift register is not really cleared immediately. It seems like
-	// the individual bits in the shift register start to fade down towards
-	// zero when test is set. All bits reach zero within approximately
-	// $2000 - $4000 cycles.
-	// This is not modeled. There should fortunately be little audible output
-	// from this peculiar behavior.
+	// The accumulator is cleared.
+	// The shift register is not reset immediately. The individual bits in
+	// the shift register start to fade up towards one when test is set, see
+	// Clock. All bits reach one within $8000 cycles on the MOS6581, and
+	// within $950000 cycles on the MOS8580.
+	// The test bit sets the pulse output high at once.
 	if test_next != 0 {
 		w.accumulator = 0
-		w.shiftreg = 0
+		w.pulseOutput = 0xfff
+		w.pulseCompare = 0xfff
+		if w.test == 0 {
+			w.shiftregAge = 0
+		}
 	} else {
 		// Test bit cleared.
 		// The accumulator starts counting, and the shift register is reset to
 		// the value 0x7ffff8.
-		// NB! The shift register will not actually be set to this exact value if the
-		// shift register bits have not had time to fade to zero.
-		// This is not modeled.
+		// The lower bits are not set by the reset, so bits that have not had
+		// time to fade to one are kept.
 		if w.test != 0 {
-			w.shiftreg = 0x7ffff8
+			w.shiftreg = 0x7ffff8 | (w.shiftreg & 0x7)
 		}
 	}
 
@@ -0,0 +1,213 @@
+package resid
+
+import "testing"
+
+// A waveform generator with the accumulator at zero, and the upper 12 bits
+// of the accumulator counting one step per cycle.
+func newTestWave(model Model, waveform reg8) *WaveformGenerator {
+	w := NewWaveformGenerator()
+	w.SetModel(model)
+	w.WriteFREQ_LO(0x00)
+	w.WriteFREQ_HI(0x10)
+	w.WriteCONTROL_REG(0x08)
+	w.Clock(1)
+	w.WriteCONTROL_REG(waveform << 4)
+	w.Clock(1)
+	w.accumulator = 0
+	return w
+}
+
+// The expected values below follow from the register descriptions of the
+// datasheet and the notes of reSID on the chip pipelines, and are not taken
+// from this implementation.
+
+// The sawtooth output is the upper 12 bits of the accumulator. The MOS8580
+// output lags the MOS6581 output by one cycle.
+func TestWaveSawtoothOutputDelay(t *testing.T) {
+	w6581 := newTestWave(MOS6581, 0x2)
+	w8580 := newTestWave(MOS8580, 0x2)
+	for c := 1; c <= 0x100; c++ {
+		w6581.Clock(1)
+		w8580.Clock(1)
+		if got := w6581.Output(); got != reg12(c) {
+			t.Fatalf("6581 cycle %d: output = %#03x, want %#03x", c, got, c)
+		}
+		if got := w8580.Output(); got != reg12(c-1) {
+			t.Fatalf("8580 cycle %d: output = %#03x, want %#03x", c, got, c-1)
+		}
+	}
+}
+
+// The pulse output is the compare of the upper 12 bits of the accumulator
+// with the pulse width, delayed one cycle after the compare.
+func TestWavePulseCompareDelay(t *testing.T) {
+	w := newTestWave(MOS6581, 0x4)
+	w.WritePW_LO(0x00)
+	w.WritePW_HI(0x08)
+
+	// The compare is true from the cycle the accumulator reaches the pulse
+	// width, and seen on the output in the next cycle.
+	w.Clock(0x800)
+	if got := w.Output(); got != 0x000 {
+		t.Fatalf("pulse at accumulator 0x800 = %#03x, want 0x000", got)
+	}
+	w.Clock(1)
+	if got := w.Output(); got != 0xfff {
+		t.Fatalf("pulse at accumulator 0x801 = %#03x, want 0xfff", got)
+	}
+
+	// A new pulse width is compared in the next cycle, and seen on the
+	// output in the cycle after.
+	w.WritePW_HI(0x0f)
+	for c := 0; c < 2; c++ {
+		if got := w.Output(); got != 0xfff {
+			t.Fatalf("pulse %d cycles after pulse width write = %#03x, want 0xfff", c, got)
+		}
+		w.Clock(1)
+	}
+	if got := w.Output(); got != 0x000 {
+		t.Fatalf("pulse 2 cycles after pulse width write = %#03x, want 0x000", got)
+	}
+}
+
+// The test bit sets the pulse output, and holds it at 0xfff.
+func TestWaveTestBitPulse(t *testing.T) {
+	for _, model := range []Model{MOS6581, MOS8580} {
+		w := newTestWave(model, 0x4)
+		w.WritePW_HI(0x08)
+		w.Clock(0x10)
+
+		w.WriteCONTROL_REG(0x48)
+		delay := 0
+		if model == MOS8580 {
+			delay = 1
+		}
+		for c := 0; c < delay; c++ {
+			if got := w.Output(); got != 0x000 {
+				t.Fatalf("model %d, cycle %d after test bit: pulse = %#03x, want 0x000", model, c, got)
+			}
+			w.Clock(1)
+		}
+		for c := delay; c < 0x1000; c++ {
+			if got := w.Output(); got != 0xfff {
+				t.Fatalf("model %d, cycle %d after test bit: pulse = %#03x, want 0xfff", model, c, got)
+			}
+			w.Clock(1)
+		}
+	}
+}
+
+// The noise output is 8 bits of a 23 bit Fibonacci LFSR with taps at bits 22
+// and 17, shifted when bit 19 of the accumulator goes high. The register is
+// reset to 0x7ffff8 by the test bit.
+func TestWaveNoiseSequence(t *testing.T) {
+	w := newTestWave(MOS6581, 0x8)
+
+	// Reference LFSR and output bits, from the reverse engineering of the
+	// noise waveform.
+	lfsr := uint32(0x7ffff8)
+	output := func() reg12 {
+		var v reg12
+		for i, bit := range []uint{22, 20, 16, 13, 11, 7, 4, 2} {
+			v |= reg12(lfsr>>bit&1) << (11 - i)
+		}
+		return v
+	}
+
+	if got := w.Output(); got != 0xfe0 {
+		t.Fatalf("noise after reset = %#03x, want 0xfe0", got)
+	}
+
+	// With the upper 12 bits of the accumulator counting one step per
+	// cycle, bit 19 goes high every 0x100 cycles from cycle 0x80.
+	w.Clock(0x80)
+	for shift := 1; shift <= 1000; shift++ {
+		lfsr = (lfsr<<1 | (lfsr>>22^lfsr>>17)&1) & 0x7fffff
+		if got, want := w.Output(), output(); got != want {
+			t.Fatalf("noise after %d shifts = %#03x, want %#03x", shift, got, want)
+		}
+		w.Clock(0x100)
+	}
+}
+
+// Clocking in batches must give the same output as clocking one cycle at a
+// time, for both models, including the combined waveforms with noise which
+// write back into the shift register.
+func TestWaveClockBatches(t *testing.T) {
+	for _, model := range []Model{MOS6581, MOS8580} {
+		for waveform := reg8(0x1); waveform <= 0xf; waveform++ {
+			run := func(batch int) []reg12 {
+				w := NewWaveformGenerator()
+				w.SetModel(model)
+				w.WriteFREQ_LO(0x35)
+				w.WriteFREQ_HI(0x2b)
+				w.WritePW_LO(0x80)
+				w.WritePW_HI(0x05)
+				w.WriteCONTROL_REG(waveform << 4)
+
+				var trace []reg12
+				for cycle := 0; cycle < 20000; cycle += batch {
+					w.Clock(CycleCount(batch))
+					if (cycle+batch)%60 == 0 {
+						trace = append(trace, w.Output())
+					}
+				}
+				return trace
+			}
+
+			want := run(1)
+			for _, batch := range []int{4, 60} {
+				got := run(batch)
+				for i := range want {
+					if got[i] != want[i] {
+						t.Fatalf("model %d, waveform %#x, batch %d, cycle %d: output = %#03x, want %#03x",
+							model, waveform, batch, (i+1)*60, got[i], want[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+// While the test bit is held, the shift register bits rise one by one to the
+// reset value 0x7fffff, which all bits reach $8000 cycles after the test bit
+// is set on the MOS6581 and $950000 cycles after on the MOS8580.
+func TestWaveShiftRegisterFade(t *testing.T) {
+	for _, test := range []struct {
+		model Model
+		ttl   CycleCount
+	}{
+		{MOS6581, 0x8000},
+		{MOS8580, 0x950000},
+	} {
+		w := newTestWave(test.model, 0x8)
+		w.shiftreg = 0x000000
+		w.WriteCONTROL_REG(0x88)
+
+		// No bit is set within the first half of the life time.
+		w.Clock(test.ttl/2 - 1)
+		if w.shiftreg != 0x000000 {
+			t.Fatalf("model %d: shift register = %#06x after %#x cycles, want 0", test.model, w.shiftreg, test.ttl/2-1)
+		}
+
+		prev := w.shiftreg
+		for age := test.ttl / 2; age < test.ttl; age += test.ttl / 64 {
+			w.Clock(test.ttl / 64)
+			if prev&^w.shiftreg != 0 {
+				t.Fatalf("model %d: shift register bits fell from %#06x to %#06x", test.model, prev, w.shiftreg)
+			}
+			prev = w.shiftreg
+		}
+		w.Clock(test.ttl)
+		if w.shiftreg != 0x7fffff {
+			t.Fatalf("model %d: shift register = %#06x after the life time, want 0x7fffff", test.model, w.shiftreg)
+		}
+
+		// Clearing the test bit resets the register to 0x7ffff8, keeping the
+		// lower bits.
+		w.WriteCONTROL_REG(0x80)
+		if w.shiftreg != 0x7fffff {
+			t.Fatalf("model %d: shift register = %#06x after test bit cleared, want 0x7fffff", test.model, w.shiftreg)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package resid
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WaveTables holds the combined waveform lookup tables of one chip, as
+// sampled from the upper 8 bits of OSC3 ($d41b) for every position of the
+// oscillator. Each table has 4096 entries.
+type WaveTables struct {
+	Name string
+	ST   []reg8 // sawtooth+triangle
+	PT   []reg8 // pulse+triangle
+	PS   []reg8 // pulse+sawtooth
+	PST  []reg8 // pulse+sawtooth+triangle
+}
+
+const waveTableSize = 4096
+
+// WaveTablesForModel returns the built-in tables of the chip model.
+func WaveTablesForModel(model Model) *WaveTables {
+	if model == MOS6581 {
+		return &WaveTables{"6581", wave6581__ST, wave6581_P_T, wave6581_PS_, wave6581_PST}
+	}
+	return &WaveTables{"8580", wave8580__ST, wave8580_P_T, wave8580_PS_, wave8580_PST}
+}
+
+// ----------------------------------------------------------------------------
+// Load combined waveform tables.
+//
+// A binary file is the four tables as raw bytes, 16384 bytes in the order
+// ST, PT, PS, PST. This is the layout of the sample dumps from reSID.
+//
+// A text file has a section for each table, started by a line with the
+// section name in brackets, followed by 4096 values separated by white
+// space or commas. Values are decimal, or hex with a 0x or $ prefix. Text
+// after # is a comment.
+//
+//	[ST]
+//	0x00, 0x00, 0x00, ...
+//	[PT]
+//	...
+//
+// ----------------------------------------------------------------------------
+func LoadWaveTables(r io.Reader, name string) (*WaveTables, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &WaveTables{Name: name}
+	if len(data) == 4*waveTableSize {
+		t.ST = bytesToReg8(data[0*waveTableSize : 1*waveTableSize])
+		t.PT = bytesToReg8(data[1*waveTableSize : 2*waveTableSize])
+		t.PS = bytesToReg8(data[2*waveTableSize : 3*waveTableSize])
+		t.PST = bytesToReg8(data[3*waveTableSize : 4*waveTableSize])
+		return t, nil
+	}
+
+	sections := map[string]*[]reg8{"ST": &t.ST, "PT": &t.PT, "PS": &t.PS, "PST": &t.PST}
+	var table *[]reg8
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			section := strings.ToUpper(strings.TrimSpace(text[1 : len(text)-1]))
+			if table = sections[section]; table == nil {
+				return nil, fmt.Errorf("wave tables line %d: unknown table %q", line, section)
+			}
+			if *table != nil {
+				return nil, fmt.Errorf("wave tables line %d: table %s defined twice", line, section)
+			}
+			*table = make([]reg8, 0, waveTableSize)
+			continue
+		}
+		if table == nil {
+			return nil, fmt.Errorf("wave tables line %d: value outside of a table section", line)
+		}
+
+		for _, field := range strings.FieldsFunc(text, func(c rune) bool { return c == ',' || c == ' ' || c == '\t' }) {
+			if strings.HasPrefix(field, "$") {
+				field = "0x" + field[1:]
+			}
+			v, err := strconv.ParseUint(field, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("wave tables line %d: %v", line, err)
+			}
+			*table = append(*table, reg8(v))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Check that all tables are present and complete.
+func (t *WaveTables) validate() error {
+	for _, table := range []struct {
+		name string
+		wave []reg8
+	}{{"ST", t.ST}, {"PT", t.PT}, {"PS", t.PS}, {"PST", t.PST}} {
+		if table.wave == nil {
+			return fmt.Errorf("wave table %s missing", table.name)
+		}
+		if len(table.wave) != waveTableSize {
+			return fmt.Errorf("wave table %s has %d entries, expected %d", table.name, len(table.wave), waveTableSize)
+		}
+	}
+	return nil
+}
+
+func bytesToReg8(b []byte) []reg8 {
+	wave := make([]reg8, len(b))
+	for i, v := range b {
+		wave[i] = reg8(v)
+	}
+	return wave
+}
@@ -0,0 +1,129 @@
+package resid
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// The binary layout is the four tables of 4096 bytes in the order ST, PT,
+// PS, PST.
+func TestLoadWaveTablesBinary(t *testing.T) {
+	data := make([]byte, 4*waveTableSize)
+	for i := range data {
+		data[i] = byte(i/waveTableSize<<6 | i%waveTableSize%61)
+	}
+
+	tables, err := LoadWaveTables(bytes.NewReader(data), "dump.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables.Name != "dump.bin" {
+		t.Errorf("name = %q, want %q", tables.Name, "dump.bin")
+	}
+	for n, wave := range [][]reg8{tables.ST, tables.PT, tables.PS, tables.PST} {
+		if len(wave) != waveTableSize {
+			t.Fatalf("table %d has %d entries, want %d", n, len(wave), waveTableSize)
+		}
+		for i, v := range wave {
+			if want := reg8(n<<6 | i%61); v != want {
+				t.Fatalf("table %d entry %d = %#02x, want %#02x", n, i, v, want)
+			}
+		}
+	}
+}
+
+// waveTablesText returns a text file with the given sections, each holding
+// 4096 values in a mix of the accepted notations.
+func waveTablesText(sections ...string) string {
+	var b strings.Builder
+	b.WriteString("# Combined waveforms\n\n")
+	for n, section := range sections {
+		fmt.Fprintf(&b, "[%s]  # table %d\n", section, n)
+		for i := 0; i < waveTableSize; i++ {
+			v := (n*37 + i) & 0xff
+			switch i % 3 {
+			case 0:
+				fmt.Fprintf(&b, "%d,", v)
+			case 1:
+				fmt.Fprintf(&b, " 0x%02x,", v)
+			case 2:
+				fmt.Fprintf(&b, "\t$%02X\n", v)
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestLoadWaveTablesText(t *testing.T) {
+	tables, err := LoadWaveTables(strings.NewReader(waveTablesText("st", "PT", "PS", "PST")), "chip.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, wave := range [][]reg8{tables.ST, tables.PT, tables.PS, tables.PST} {
+		if len(wave) != waveTableSize {
+			t.Fatalf("table %d has %d entries, want %d", n, len(wave), waveTableSize)
+		}
+		for i, v := range wave {
+			if want := reg8((n*37 + i) & 0xff); v != want {
+				t.Fatalf("table %d entry %d = %#02x, want %#02x", n, i, v, want)
+			}
+		}
+	}
+}
+
+func TestLoadWaveTablesRejected(t *testing.T) {
+	full := waveTablesText("ST", "PT", "PS", "PST")
+	lastLine := strings.LastIndex(strings.TrimSpace(full), "\n")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"short binary", string(make([]byte, 4*waveTableSize-1))},
+		{"missing table", waveTablesText("ST", "PT", "PS")},
+		{"table defined twice", waveTablesText("ST", "PT", "PS", "PST", "PT")},
+		{"unknown table", waveTablesText("ST", "PT", "PS", "PST", "TN")},
+		{"short table", full[:lastLine]},
+		{"long table", full + "0\n"},
+		{"value out of range", strings.Replace(full, "0x01,", "0x101,", 1)},
+		{"bad value", strings.Replace(full, "0x01,", "0x0g,", 1)},
+		{"value outside of a table", "1, 2, 3\n" + full},
+	}
+	for _, test := range tests {
+		if _, err := LoadWaveTables(strings.NewReader(test.data), test.name); err == nil {
+			t.Errorf("%s: loaded, want an error", test.name)
+		}
+	}
+}
+
+// Incomplete tables set directly are rejected, and the tables in use kept.
+func TestSetWaveTablesRejected(t *testing.T) {
+	s := NewSID()
+	s.Write(0x04, 0x61)
+	s.Write(0x01, 0x10)
+
+	short := WaveTablesForModel(MOS6581)
+	short.PS = short.PS[:100]
+	for _, tables := range []*WaveTables{{Name: "empty"}, short} {
+		if err := s.SetWaveTables(tables); err == nil {
+			t.Errorf("%s tables accepted, want an error", tables.Name)
+		}
+		if err := s.voice[0].Wave.SetWaveTables(tables); err == nil {
+			t.Errorf("%s tables accepted by the waveform generator, want an error", tables.Name)
+		}
+	}
+	for c := 0; c < 0x2000; c++ {
+		s.Clock(1)
+		s.voice[0].Wave.Output()
+	}
+
+	if err := s.SetWaveTables(WaveTablesForModel(MOS8580)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetWaveTables(nil); err != nil {
+		t.Fatal(err)
+	}
+}
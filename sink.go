@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// AudioSink plays or stores the mono samples of an audio stream.
+type AudioSink interface {
+	// Open prepares the sink for the sample rate, and returns the number of
+	// samples it reads at a time.
+	Open(sampleRate uint32) (int, error)
+
+	// Start starts reading from the stream, from another goroutine or
+	// thread.
+	Start(stream *audioStream)
+
+	// Close stops reading and releases the sink. Closing again does
+	// nothing.
+	Close() error
+}
+
+// newAudioSink returns the sink for an output: sdl, null, raw for 16-bit
+// samples on stdout, or wav:<file>. The buffer is the number of samples
+// read at a time.
+func newAudioSink(output string, buffer int) (AudioSink, error) {
+	switch {
+	case output == "sdl":
+		return newSDLSink(buffer)
+	case output == "null":
+		return newPacedSink(buffer, nil, nil), nil
+	case output == "raw":
+		return newRawSink(buffer, os.Stdout), nil
+	case strings.HasPrefix(output, "wav:"):
+		return newWavSink(buffer, strings.TrimPrefix(output, "wav:"))
+	}
+	return nil, fmt.Errorf("unknown audio output %q", output)
+}
+
+// pacedSink reads from the stream in real time, for outputs without a clock
+// of their own.
+type pacedSink struct {
+	buffer     int
+	sampleRate uint32
+
+	// Output of the samples and closing of the output, nil for none.
+	write func(samples []int16) error
+	close func() error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPacedSink(buffer int, write func(samples []int16) error, close func() error) *pacedSink {
+	return &pacedSink{buffer: buffer, write: write, close: close}
+}
+
+func (p *pacedSink) Open(sampleRate uint32) (int, error) {
+	p.sampleRate = sampleRate
+	return p.buffer, nil
+}
+
+func (p *pacedSink) Start(stream *audioStream) {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(stream)
+}
+
+func (p *pacedSink) run(stream *audioStream) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	buf := make([]int16, p.buffer)
+	start := time.Now()
+	var read uint64
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+
+		due := uint64(time.Since(start).Seconds() * float64(p.sampleRate))
+		for read+uint64(len(buf)) <= due {
+			stream.read(buf)
+			read += uint64(len(buf))
+			if p.write == nil {
+				continue
+			}
+			if err := p.write(buf); err != nil {
+				fmt.Fprintf(messages, "Warning: audio output stopped (%v)\n", err)
+				p.write = nil
+			}
+		}
+	}
+}
+
+func (p *pacedSink) Close() error {
+	if p.stop != nil {
+		close(p.stop)
+		<-p.done
+		p.stop = nil
+	}
+	if p.close == nil {
+		return nil
+	}
+	err := p.close()
+	p.close = nil
+	return err
+}
+
+// newRawSink writes 16-bit little endian samples to out. Messages must go
+// elsewhere if out is stdout, see messages.
+func newRawSink(buffer int, out io.Writer) AudioSink {
+	var bytes []byte
+	return newPacedSink(buffer, func(samples []int16) error {
+		bytes = bytes[:0]
+		for _, v := range samples {
+			bytes = binary.LittleEndian.AppendUint16(bytes, uint16(v))
+		}
+		_, err := out.Write(bytes)
+		return err
+	}, nil)
+}
+
+// wavSink writes a mono WAV file.
+type wavSink struct {
+	*pacedSink
+	file   *os.File
+	writer *WavWriter
+}
+
+func newWavSink(buffer int, fileName string) (AudioSink, error) {
+	if fileName == "" {
+		return nil, errors.New("expected wav:<file>")
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wavSink{file: file}
+	w.pacedSink = newPacedSink(buffer, func(samples []int16) error {
+		return w.writer.Write(samples)
+	}, w.closeFile)
+	return w, nil
+}
+
+func (w *wavSink) Open(sampleRate uint32) (int, error) {
+	writer, err := NewWavWriter(w.file, sampleRate, 1)
+	if err != nil {
+		return 0, err
+	}
+	w.writer = writer
+	return w.pacedSink.Open(sampleRate)
+}
+
+func (w *wavSink) closeFile() error {
+	var err error
+	if w.writer != nil {
+		err = w.writer.Close()
+	}
+	return errors.Join(err, w.file.Close())
+}
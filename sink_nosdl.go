@@ -0,0 +1,9 @@
+//go:build !cgo || nosdl
+
+package main
+
+import "errors"
+
+func newSDLSink(buffer int) (AudioSink, error) {
+	return nil, errors.New("built without SDL audio, use -out null, raw or wav:<file>")
+}
@@ -0,0 +1,109 @@
+//go:build cgo && !nosdl
+
+package main
+
+// #include <stdint.h>
+// #include <stdlib.h>
+// typedef unsigned char Uint8;
+// void OnAudioCallback(void *userdata, Uint8 *stream, int len);
+import "C"
+
+import (
+	"runtime/cgo"
+	"unsafe"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// sdlOutput is the state of the SDL audio callback, passed to it as a
+// handle in C memory.
+type sdlOutput struct {
+	stream *audioStream
+	mono   []int16
+}
+
+//export OnAudioCallback
+func OnAudioCallback(userdata unsafe.Pointer, stream *C.Uint8, length C.int) {
+	out := cgo.Handle(*(*C.uintptr_t)(userdata)).Value().(*sdlOutput)
+
+	// Stereo 16-bit samples, the mono output on both channels.
+	frames := unsafe.Slice((*int16)(unsafe.Pointer(stream)), int(length)/2)
+	samples := len(frames) / 2
+	if cap(out.mono) < samples {
+		out.mono = make([]int16, samples)
+	}
+	mono := out.mono[:samples]
+	out.stream.read(mono)
+
+	for i, sample := range mono {
+		frames[2*i] = sample
+		frames[2*i+1] = sample
+	}
+}
+
+// sdlSink plays through an SDL audio device, which reads the stream from its
+// own thread.
+type sdlSink struct {
+	buffer   int
+	output   *sdlOutput
+	handle   cgo.Handle
+	userdata unsafe.Pointer
+	dev      sdl.AudioDeviceID
+}
+
+func newSDLSink(buffer int) (AudioSink, error) {
+	return &sdlSink{buffer: buffer}, nil
+}
+
+func (s *sdlSink) Open(sampleRate uint32) (int, error) {
+	if err := sdl.Init(sdl.INIT_AUDIO); err != nil {
+		return 0, err
+	}
+
+	s.output = &sdlOutput{}
+	s.handle = cgo.NewHandle(s.output)
+	s.userdata = C.malloc(C.size_t(unsafe.Sizeof(C.uintptr_t(0))))
+	*(*C.uintptr_t)(s.userdata) = C.uintptr_t(s.handle)
+
+	spec := &sdl.AudioSpec{}
+	spec.Callback = sdl.AudioCallback(C.OnAudioCallback)
+	spec.UserData = s.userdata
+	spec.Samples = uint16(s.buffer)
+	spec.Channels = 2
+	spec.Freq = int32(sampleRate)
+	spec.Format = sdl.AUDIO_S16SYS
+
+	obtained := &sdl.AudioSpec{}
+	dev, err := sdl.OpenAudioDevice("", false, spec, obtained, 0)
+	if err != nil {
+		s.release()
+		return 0, err
+	}
+	s.dev = dev
+	return int(obtained.Samples), nil
+}
+
+func (s *sdlSink) Start(stream *audioStream) {
+	s.output.stream = stream
+	sdl.PauseAudioDevice(s.dev, false)
+}
+
+func (s *sdlSink) Close() error {
+	if s.dev != 0 {
+		sdl.PauseAudioDevice(s.dev, true)
+		sdl.CloseAudioDevice(s.dev)
+		s.dev = 0
+	}
+	s.release()
+	return nil
+}
+
+func (s *sdlSink) release() {
+	if s.userdata == nil {
+		return
+	}
+	s.handle.Delete()
+	C.free(s.userdata)
+	s.userdata = nil
+	sdl.Quit()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+)
+
+// The raw output holds the samples and nothing else, the messages go to
+// their own writer.
+func TestRawSinkOutput(t *testing.T) {
+	var msgs, out bytes.Buffer
+	messages = &msgs
+	defer func() { messages = os.Stdout }()
+
+	player := NewSidPlayer()
+	player.Load(writeTestTune(t, volumeDigiTune))
+	player.Init()
+	player.Start()
+	defer player.Stop()
+
+	sink := newRawSink(256, &out)
+	deviceSamples, err := sink.Open(player.sampleFreq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newAudioStream(player, player.sampleFreq, 500*time.Millisecond, deviceSamples)
+	stream.start()
+	sink.Start(stream)
+	time.Sleep(100 * time.Millisecond)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	stream.close()
+
+	if msgs.Len() == 0 {
+		t.Error("no messages")
+	}
+	if out.Len() == 0 || out.Len()%(2*deviceSamples) != 0 {
+		t.Fatalf("raw output of %d bytes, want whole reads of %d samples", out.Len(), deviceSamples)
+	}
+
+	ref := NewSidPlayer()
+	ref.Load(writeTestTune(t, volumeDigiTune))
+	ref.Init()
+	ref.Start()
+	defer ref.Stop()
+	want := make([]int16, out.Len()/2)
+	ref.Render(want)
+	var wantBytes []byte
+	for _, v := range want {
+		wantBytes = binary.LittleEndian.AppendUint16(wantBytes, uint16(v))
+	}
+	if !bytes.Equal(out.Bytes(), wantBytes) {
+		t.Error("raw output differs from the rendered samples")
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	resid "yaspg/app/sid"
+)
+
+// Machine snapshot format: magic and version, followed by the CPU, the 64K
+// of RAM including the I/O area, the player state and the SID state, in
+// little endian byte order. The version is raised whenever the layout
+// changes.
+const (
+	SNAPSHOT_MAGIC   = "YASPGSNP"
+	SNAPSHOT_VERSION = 2
+)
+
+// The most SID writes a snapshot can hold: a frame is at most a CIA timer
+// period of $10000 cycles, and a read-modify-write instruction writes twice
+// in 6 cycles.
+const SNAPSHOT_MAX_SID_WRITES = 0x10000 / 3
+
+// snapshotHeader is the configuration a snapshot was taken with. A snapshot
+// is only loaded by a player set up the same way. The DAC model, filter
+// curve, wave tables and external filter are named by a hash of their
+// names or file names.
+type snapshotHeader struct {
+	Magic       [8]byte
+	Version     uint16
+	Model       uint8
+	Video       uint8
+	FilterType  uint8
+	Sampling    uint8
+	SampleFreq  uint32
+	DACModel    uint64
+	FilterCurve uint64
+	WaveTables  uint64
+	ExtFilter   uint64
+	ExtFilterHP float64
+	ExtFilterLP float64
+	OutputClip  uint8
+	DigiBoost   bool
+}
+
+// cpuState is the register file and the cycle counter of the CPU.
+type cpuState struct {
+	A, X, Y, SP uint8
+	PC, LastPC  uint16
+	Status      uint8
+	Cycles      uint64
+}
+
+// playerState is the playback state of the player.
+type playerState struct {
+	CurrentSong   uint16
+	PlayAddress   uint16
+	FramePeriod   uint32
+	FrameRate     float64
+	FrameCycles   int64
+	FrameClock    int64
+	TickCycles    uint64
+	ElapsedCycles uint64
+	PotX, PotY    uint8
+	MouseX        int32
+	MouseY        int32
+	PaddleNext    int32
+	InputPos      float64
+	SidWrites     uint32
+}
+
+// sidWriteState is a queued SID register write, following the player state.
+type sidWriteState struct {
+	Cycle      int64
+	Reg, Value uint8
+}
+
+// SaveState writes a snapshot of the whole machine. Loading it continues
+// playback bit-identically.
+func (s *SidPlayer) SaveState(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.saveState(w)
+}
+
+func (s *SidPlayer) saveState(w io.Writer) error {
+	if !s.isPlaying {
+		return errors.New("not playing")
+	}
+
+	header := s.snapshotHeader()
+	player := playerState{
+		CurrentSong:   s.currentSong,
+		PlayAddress:   s.songHeader.PlayAddress,
+		FramePeriod:   s.framePeriod,
+		FrameRate:     s.frameRate,
+		FrameCycles:   int64(s.frameCycles),
+		FrameClock:    int64(s.frameClock),
+		TickCycles:    s.tickCycles,
+		ElapsedCycles: s.elapsedCycles,
+		PotX:          s.potx,
+		PotY:          s.poty,
+		MouseX:        int32(s.mouseX),
+		MouseY:        int32(s.mouseY),
+		PaddleNext:    int32(s.paddleNext),
+		SidWrites:     uint32(len(s.sidWrites)),
+	}
+	if s.extInput != nil {
+		player.InputPos = s.extInput.pos
+	}
+
+	for _, v := range []any{&header, s.cpuState(), s.mem.b[:], &player} {
+		if err := binary.Write(w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	for _, sw := range s.sidWrites {
+		write := sidWriteState{int64(sw.cycle), sw.reg, sw.value}
+		if err := binary.Write(w, binary.LittleEndian, &write); err != nil {
+			return err
+		}
+	}
+	return s.sid.SaveState(w)
+}
+
+// LoadState restores a snapshot written by SaveState. The player must have
+// the tune loaded and be set up the same way as when the snapshot was taken.
+// On a read error the player is left as it was.
+func (s *SidPlayer) LoadState(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.loadState(r)
+}
+
+func (s *SidPlayer) loadState(r io.Reader) error {
+	if !s.isLoaded {
+		return errors.New("no tune loaded")
+	}
+
+	var header snapshotHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return err
+	}
+	if string(header.Magic[:]) != SNAPSHOT_MAGIC {
+		return errors.New("not a snapshot")
+	}
+	if header.Version != SNAPSHOT_VERSION {
+		return fmt.Errorf("unsupported snapshot version %d", header.Version)
+	}
+	if header != s.snapshotHeader() {
+		return errors.New("snapshot of a player set up differently")
+	}
+
+	var cpu cpuState
+	var mem [64 * 1024]byte
+	var player playerState
+	for _, v := range []any{&cpu, mem[:], &player} {
+		if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if player.SidWrites > SNAPSHOT_MAX_SID_WRITES {
+		return fmt.Errorf("snapshot with %d SID writes pending", player.SidWrites)
+	}
+	sidWrites := make([]sidWrite, player.SidWrites)
+	for i := range sidWrites {
+		var write sidWriteState
+		if err := binary.Read(r, binary.LittleEndian, &write); err != nil {
+			return err
+		}
+		sidWrites[i] = sidWrite{resid.CycleCount(write.Cycle), write.Reg, write.Value}
+	}
+
+	if !s.isInitialized {
+		s.Init()
+	}
+	if err := s.sid.LoadState(r); err != nil {
+		return err
+	}
+
+	s.setCPUState(&cpu)
+	s.mem.b = mem
+	s.currentSong = player.CurrentSong
+	s.songHeader.PlayAddress = player.PlayAddress
+	s.framePeriod = player.FramePeriod
+	s.frameRate = player.FrameRate
+	s.frameCycles = resid.CycleCount(player.FrameCycles)
+	s.frameClock = resid.CycleCount(player.FrameClock)
+	s.tickCycles = player.TickCycles
+	s.elapsedCycles = player.ElapsedCycles
+	s.potx, s.poty = player.PotX, player.PotY
+	s.mouseX, s.mouseY = int(player.MouseX), int(player.MouseY)
+	s.paddleNext = int(player.PaddleNext)
+	s.sidWrites = sidWrites
+	if s.extInput != nil {
+		s.extInput.setClock(s.clockFreq)
+		s.extInput.pos = player.InputPos
+	}
+	s.isPlaying = true
+	return nil
+}
+
+func (s *SidPlayer) snapshotHeader() snapshotHeader {
+	header := snapshotHeader{
+		Version:     SNAPSHOT_VERSION,
+		Model:       uint8(s.model),
+		Video:       uint8(s.video),
+		FilterType:  uint8(s.filterType),
+		Sampling:    uint8(s.sampling),
+		SampleFreq:  s.sampleFreq,
+		DACModel:    configHash(s.dacModel),
+		FilterCurve: configHash(s.filterCurve),
+		WaveTables:  configHash(s.waveTables),
+		ExtFilter:   configHash(s.extFilter),
+		ExtFilterHP: s.extFilterHP,
+		ExtFilterLP: s.extFilterLP,
+		OutputClip:  uint8(s.outputClip),
+		DigiBoost:   s.digiBoost,
+	}
+	copy(header.Magic[:], SNAPSHOT_MAGIC)
+	return header
+}
+
+// configHash is the FNV-1a hash of a setting given by name.
+func configHash(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return h.Sum64()
+}
+
+func (s *SidPlayer) cpuState() *cpuState {
+	reg := &s.cpu.Reg
+	return &cpuState{
+		A:      reg.A,
+		X:      reg.X,
+		Y:      reg.Y,
+		SP:     reg.SP,
+		PC:     reg.PC,
+		LastPC: s.cpu.LastPC,
+		Status: reg.SavePS(false),
+		Cycles: s.cpu.Cycles,
+	}
+}
+
+func (s *SidPlayer) setCPUState(state *cpuState) {
+	reg := &s.cpu.Reg
+	reg.A, reg.X, reg.Y, reg.SP, reg.PC = state.A, state.X, state.Y, state.SP, state.PC
+	reg.RestorePS(state.Status)
+	s.cpu.LastPC = state.LastPC
+	s.cpu.Cycles = state.Cycles
+}
+
+// saveSnapshot writes a machine snapshot to a file.
+func (s *SidPlayer) saveSnapshot(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := s.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// loadSnapshot restores a machine snapshot from a file.
+func (s *SidPlayer) loadSnapshot(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return s.LoadState(file)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// startTestPlayer plays the volume register digi from its start.
+func startTestPlayer(t *testing.T) *SidPlayer {
+	player := NewSidPlayer()
+	player.Load(writeTestTune(t, volumeDigiTune))
+	player.Init()
+	player.Start()
+	return player
+}
+
+func TestPlayerSnapshotRestore(t *testing.T) {
+	player := startTestPlayer(t)
+	defer player.Stop()
+	buf := make([]int16, 3001)
+	player.Render(buf)
+
+	var snapshot bytes.Buffer
+	if err := player.SaveState(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	at := player.Elapsed()
+	want := make([]int16, 20000)
+	player.Render(want)
+
+	restored := startTestPlayer(t)
+	defer restored.Stop()
+	if err := restored.LoadState(bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Elapsed(); got != at {
+		t.Errorf("restored at %v, want %v", got, at)
+	}
+	got := make([]int16, len(want))
+	restored.Render(got)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d after restoring = %d, want %d", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPlayerSnapshotErrors(t *testing.T) {
+	player := startTestPlayer(t)
+	defer player.Stop()
+	player.Render(make([]int16, 3000))
+	var snapshot bytes.Buffer
+	if err := player.SaveState(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// A snapshot asking for more SID writes than a frame can hold.
+	hostile := bytes.Clone(snapshot.Bytes())
+	offset := binary.Size(snapshotHeader{}) + binary.Size(cpuState{}) + len(player.mem.b) + binary.Size(playerState{}) - 4
+	binary.LittleEndian.PutUint32(hostile[offset:], 0xffffffff)
+
+	snapshots := map[string][]byte{
+		"too many SID writes": hostile,
+		"truncated header":    snapshot.Bytes()[:10],
+		"truncated memory":    snapshot.Bytes()[:offset-1000],
+		"truncated SID state": snapshot.Bytes()[:snapshot.Len()-10],
+	}
+	for name, data := range snapshots {
+		// A failed load leaves the player as it was.
+		p := startTestPlayer(t)
+		p.Render(make([]int16, 1000))
+		ref := startTestPlayer(t)
+		ref.Render(make([]int16, 1000))
+
+		if err := p.LoadState(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+		got, want := make([]int16, 5000), make([]int16, 5000)
+		p.Render(got)
+		ref.Render(want)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: player changed by a failed load", name)
+				break
+			}
+		}
+		p.Stop()
+		ref.Stop()
+	}
+}
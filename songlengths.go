@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SongLengths holds the playing times of the subtunes of each tune in a
+// Songlengths.md5 file of the HVSC, by the MD5 of the whole tune file.
+type SongLengths map[string][]time.Duration
+
+// LoadSongLengths reads a Songlengths.md5 file, with lines like
+//
+//	; /MUSICIANS/H/Hubbard_Rob/Commando.sid
+//	a3a6ae0f1aa2c5e5e0da6fd0fd4b2ea4=4:46 0:21 0:08
+//
+// Times may have milliseconds, and old style suffixes like "(G)" are
+// ignored.
+func LoadSongLengths(fileName string) (SongLengths, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lengths := SongLengths{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == ';' || text[0] == '[' {
+			continue
+		}
+
+		hash, times, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected <md5>=<times>", fileName, line)
+		}
+		var durations []time.Duration
+		for _, field := range strings.Fields(times) {
+			if i := strings.IndexByte(field, '('); i >= 0 {
+				field = field[:i]
+			}
+			d, err := parseTime(field)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", fileName, line, err)
+			}
+			durations = append(durations, d)
+		}
+		lengths[strings.ToLower(strings.TrimSpace(hash))] = durations
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lengths, nil
+}
+
+// Lookup returns the playing time of subtune num, counting from 0, of the
+// tune file with the given contents.
+func (sl SongLengths) Lookup(data []byte, num int) (time.Duration, bool) {
+	sum := md5.Sum(data)
+	durations := sl[hex.EncodeToString(sum[:])]
+	if num < 0 || num >= len(durations) {
+		return 0, false
+	}
+	return durations[num], true
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSongLengths(t *testing.T) {
+	// The MD5 of "tune" and of "other".
+	const file = `[Database]
+; /MUSICIANS/T/Test/Tune.sid
+7CE1689483ACD43D5BC46598B11BD139=1:30 0:05.5(G) 2:00.250
+
+; /MUSICIANS/T/Test/Other.sid
+795f3202b17cb6bc3d4b771d8c6c9eaf= 0:42
+`
+	fileName := filepath.Join(t.TempDir(), "Songlengths.md5")
+	if err := os.WriteFile(fileName, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lengths, err := LoadSongLengths(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data   string
+		num    int
+		length time.Duration
+		ok     bool
+	}{
+		{"tune", 0, 90 * time.Second, true},
+		{"tune", 1, 5500 * time.Millisecond, true},
+		{"tune", 2, 120250 * time.Millisecond, true},
+		{"tune", 3, 0, false},
+		{"tune", -1, 0, false},
+		{"other", 0, 42 * time.Second, true},
+		{"missing", 0, 0, false},
+	}
+	for _, test := range tests {
+		length, ok := lengths.Lookup([]byte(test.data), test.num)
+		if length != test.length || ok != test.ok {
+			t.Errorf("%s subtune %d: %v, %v, want %v, %v", test.data, test.num, length, ok, test.length, test.ok)
+		}
+	}
+}
+
+func TestSongLengthsErrors(t *testing.T) {
+	for _, file := range []string{
+		"7CE1689483ACD43D5BC46598B11BD139 1:30\n",
+		"7CE1689483ACD43D5BC46598B11BD139=1:x\n",
+		"7CE1689483ACD43D5BC46598B11BD139=1:-10\n",
+	} {
+		fileName := filepath.Join(t.TempDir(), "Songlengths.md5")
+		if err := os.WriteFile(fileName, []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSongLengths(fileName); err == nil {
+			t.Errorf("%q: no error", file)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	resid "yaspg/app/sid"
+)
+
+// StemRecorder records the output mix together with the taps of the three
+// voices, either as the channels of one WAV file or as separate files.
+type StemRecorder struct {
+	// Record the voice outputs before the filter instead of the voice
+	// contributions to the mix.
+	preFilter bool
+
+	files   []*os.File
+	writers []*WavWriter
+
+	// Interleaved frames, and the per-sample taps of the current buffer.
+	frames []int16
+	taps   [][3]resid.VoiceTap
+}
+
+// stemNames are the channels of a stem recording, in order, and the
+// suffixes of the separate files.
+var stemNames = []string{"mix", "voice1", "voice2", "voice3"}
+
+// NewStemRecorder creates the stem recording. With separate files, the file
+// name gets the stem name appended, e.g. tune_voice1.wav for tune.wav.
+func NewStemRecorder(fileName string, sampleRate uint32, separate bool, preFilter bool) (*StemRecorder, error) {
+	r := &StemRecorder{preFilter: preFilter}
+
+	names := []string{fileName}
+	channels := uint16(len(stemNames))
+	if separate {
+		ext := filepath.Ext(fileName)
+		names = names[:0]
+		for _, stem := range stemNames {
+			names = append(names, strings.TrimSuffix(fileName, ext)+"_"+stem+ext)
+		}
+		channels = 1
+	}
+
+	for _, name := range names {
+		file, err := os.Create(name)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, file)
+
+		writer, err := NewWavWriter(file, sampleRate, channels)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.writers = append(r.writers, writer)
+	}
+	return r, nil
+}
+
+// tapBuffer returns a buffer for the taps of n samples.
+func (r *StemRecorder) tapBuffer(n int) [][3]resid.VoiceTap {
+	if cap(r.taps) < n {
+		r.taps = make([][3]resid.VoiceTap, n)
+	}
+	return r.taps[:n]
+}
+
+// write records the mix and the voice taps of the same samples.
+func (r *StemRecorder) write(mix []int16, taps [][3]resid.VoiceTap) error {
+	for c, writer := range r.writers {
+		r.frames = r.frames[:0]
+		for i, sample := range mix {
+			if len(r.writers) == 1 || c == 0 {
+				r.frames = append(r.frames, sample)
+			}
+			for v, tap := range taps[i] {
+				if len(r.writers) == 1 || c == v+1 {
+					if r.preFilter {
+						r.frames = append(r.frames, tap.Voice)
+					} else {
+						r.frames = append(r.frames, tap.Mix)
+					}
+				}
+			}
+		}
+		if err := writer.Write(r.frames); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close finishes the WAV files.
+func (r *StemRecorder) Close() error {
+	var errs []error
+	for _, writer := range r.writers {
+		errs = append(errs, writer.Close())
+	}
+	for _, file := range r.files {
+		errs = append(errs, file.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -1,11 +1,45 @@
 package main
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 func check(e error) {
 	if e != nil {
 		panic(e)
 	}
 }
 
+// parseTime parses a time given as seconds, or as minutes:seconds, e.g.
+// "90", "1.5" or "2:30".
+func parseTime(s string) (time.Duration, error) {
+	orig := s
+	minutes := 0
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		m, err := strconv.Atoi(s[:i])
+		if err != nil || m < 0 {
+			return 0, fmt.Errorf("invalid time %q", orig)
+		}
+		minutes = m
+		s = s[i+1:]
+	}
+
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid time %q", orig)
+	}
+	return time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second)), nil
+}
+
+// formatTime formats a time as minutes:seconds with tenths, e.g. "2:30.5".
+func formatTime(d time.Duration) string {
+	tenths := d.Milliseconds() / 100
+	return fmt.Sprintf("%d:%02d.%d", tenths/600, tenths/10%60, tenths%10)
+}
+
 // // func absInt(x int) int {
 // // 	return absDiffInt(x, 0)
 // // }
@@ -0,0 +1,56 @@
+package main
+
+import psid "yaspg/app/psid"
+
+// VideoStandard selects the C64 video standard, which determines the CPU
+// and SID clock as well as the raster geometry.
+type VideoStandard int
+
+const (
+	PAL VideoStandard = iota
+	NTSC
+	NTSC_OLD
+	DREAN
+)
+
+// VideoTiming describes the clock and raster geometry of a video standard.
+type VideoTiming struct {
+	Name          string
+	ClockFreq     uint32
+	CyclesPerLine uint32
+	LinesPerFrame uint32
+}
+
+var videoTimings = [...]VideoTiming{
+	PAL:      {"PAL", 985248, 63, 312},
+	NTSC:     {"NTSC", 1022727, 65, 263},
+	NTSC_OLD: {"NTSC (old)", 1022727, 64, 262},
+	DREAN:    {"Drean", 1023440, 65, 312},
+}
+
+// Timing returns the timing of the video standard, PAL if it is unknown.
+func (v VideoStandard) Timing() *VideoTiming {
+	if v < PAL || int(v) >= len(videoTimings) {
+		return &videoTimings[PAL]
+	}
+	return &videoTimings[v]
+}
+
+// FramePeriod returns the number of CPU cycles in one video frame.
+func (t *VideoTiming) FramePeriod() uint32 {
+	return t.CyclesPerLine * t.LinesPerFrame
+}
+
+// FrameRate returns the number of video frames per second.
+func (t *VideoTiming) FrameRate() float64 {
+	return float64(t.ClockFreq) / float64(t.FramePeriod())
+}
+
+// videoStandardFromHeader picks the video standard a tune asks for in its
+// header. Tunes that do not care, or do not say, are played as PAL.
+func videoStandardFromHeader(header *psid.PSIDHeader) VideoStandard {
+	if header.Clock() == psid.CLOCK_NTSC {
+		return NTSC
+	}
+	return PAL
+}
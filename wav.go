@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// WAV format tags.
+const (
+	WAVE_FORMAT_PCM        = 0x0001
+	WAVE_FORMAT_IEEE_FLOAT = 0x0003
+	WAVE_FORMAT_EXTENSIBLE = 0xfffe
+)
+
+// WavData is the audio of a WAV file, mixed down to mono.
+type WavData struct {
+	SampleRate uint32
+	Channels   uint16
+	Samples    []float32 // normalized to [-1, 1]
+}
+
+// wavFormat is the "fmt " chunk of a WAV file.
+type wavFormat struct {
+	FormatTag     uint16
+	Channels      uint16
+	SampleRate    uint32
+	ByteRate      uint32
+	BlockAlign    uint16
+	BitsPerSample uint16
+}
+
+// Longest format chunk read, well above the 40 bytes of the extensible
+// format.
+const WAV_MAX_FORMAT_SIZE = 1024
+
+// ReadWav reads a WAV file with 8, 16, 24 or 32-bit PCM or 32-bit float
+// samples. Multiple channels are mixed down to mono. Chunk sizes are not
+// trusted; no more is read than the file holds.
+func ReadWav(r io.Reader) (*WavData, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	var format *wavFormat
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			if err == io.EOF {
+				return nil, errors.New("WAV file has no data")
+			}
+			return nil, err
+		}
+
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if chunk.Size < 16 {
+				return nil, errors.New("WAV format chunk too short")
+			}
+			if chunk.Size > WAV_MAX_FORMAT_SIZE {
+				return nil, errors.New("WAV format chunk too long")
+			}
+			format = &wavFormat{}
+			if err := binary.Read(r, binary.LittleEndian, format); err != nil {
+				return nil, err
+			}
+			ext := make([]byte, chunk.Size-16+chunk.Size&1)
+			if _, err := io.ReadFull(r, ext); err != nil {
+				return nil, err
+			}
+			// The extensible format has the actual format tag first in the
+			// sub format GUID.
+			if format.FormatTag == WAVE_FORMAT_EXTENSIBLE && len(ext) >= 10 {
+				format.FormatTag = binary.LittleEndian.Uint16(ext[8:10])
+			}
+
+		case "data":
+			if format == nil {
+				return nil, errors.New("WAV data before format")
+			}
+			// Accept a truncated data chunk, as left by an interrupted
+			// recording.
+			data, err := io.ReadAll(io.LimitReader(r, int64(chunk.Size)))
+			if err != nil {
+				return nil, err
+			}
+			return decodeWav(format, data)
+
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(chunk.Size+chunk.Size&1)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func decodeWav(format *wavFormat, data []byte) (*WavData, error) {
+	bytesPerSample := int(format.BitsPerSample+7) / 8
+	channels := int(format.Channels)
+	if channels == 0 || bytesPerSample == 0 {
+		return nil, errors.New("invalid WAV format")
+	}
+
+	var sample func(b []byte) float64
+	switch {
+	case format.FormatTag == WAVE_FORMAT_PCM && bytesPerSample == 1:
+		sample = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case format.FormatTag == WAVE_FORMAT_PCM && bytesPerSample == 2:
+		sample = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case format.FormatTag == WAVE_FORMAT_PCM && bytesPerSample == 3:
+		sample = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)) / (1 << 31)
+		}
+	case format.FormatTag == WAVE_FORMAT_PCM && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case format.FormatTag == WAVE_FORMAT_IEEE_FLOAT && bytesPerSample == 4:
+		sample = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return nil, fmt.Errorf("unsupported WAV format %#x with %d bits per sample", format.FormatTag, format.BitsPerSample)
+	}
+
+	frameSize := bytesPerSample * channels
+	wav := &WavData{
+		SampleRate: format.SampleRate,
+		Channels:   format.Channels,
+		Samples:    make([]float32, len(data)/frameSize),
+	}
+	for i := range wav.Samples {
+		frame := data[i*frameSize:]
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += sample(frame[c*bytesPerSample:])
+		}
+		wav.Samples[i] = float32(sum / float64(channels))
+	}
+
+	return wav, nil
+}
+
+// WavWriter writes 16-bit PCM or 32-bit float samples to a WAV file. The
+// chunk sizes are filled in by Close, so the file must be seekable.
+type WavWriter struct {
+	w          io.WriteSeeker
+	format     uint16
+	sampleRate uint32
+	channels   uint16
+	frames     uint32
+	buf        []byte
+}
+
+// NewWavWriter writes the WAV header for 16-bit PCM at the given sample rate
+// and number of channels.
+func NewWavWriter(w io.WriteSeeker, sampleRate uint32, channels uint16) (*WavWriter, error) {
+	return newWavWriter(w, WAVE_FORMAT_PCM, sampleRate, channels)
+}
+
+// NewFloatWavWriter writes the WAV header for 32-bit float samples at the
+// given sample rate and number of channels. The samples written are still
+// 16-bit, so a float file holds no more precision than a PCM one.
+func NewFloatWavWriter(w io.WriteSeeker, sampleRate uint32, channels uint16) (*WavWriter, error) {
+	return newWavWriter(w, WAVE_FORMAT_IEEE_FLOAT, sampleRate, channels)
+}
+
+func newWavWriter(w io.WriteSeeker, format uint16, sampleRate uint32, channels uint16) (*WavWriter, error) {
+	ww := &WavWriter{w: w, format: format, sampleRate: sampleRate, channels: channels}
+	if err := ww.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+func (ww *WavWriter) bytesPerSample() uint16 {
+	if ww.format == WAVE_FORMAT_IEEE_FLOAT {
+		return 4
+	}
+	return 2
+}
+
+// writeHeader writes the RIFF header, the format chunk and the data chunk
+// header. Float files have the extension size in the format chunk and a
+// fact chunk with the number of frames, as the format requires for
+// anything but PCM.
+func (ww *WavWriter) writeHeader() error {
+	blockAlign := ww.channels * ww.bytesPerSample()
+	dataSize := ww.frames * uint32(blockAlign)
+	format := wavFormat{
+		FormatTag:     ww.format,
+		Channels:      ww.channels,
+		SampleRate:    ww.sampleRate,
+		ByteRate:      ww.sampleRate * uint32(blockAlign),
+		BlockAlign:    blockAlign,
+		BitsPerSample: 8 * ww.bytesPerSample(),
+	}
+
+	var chunks []any
+	if ww.format == WAVE_FORMAT_PCM {
+		chunks = []any{[4]byte{'f', 'm', 't', ' '}, uint32(16), format}
+	} else {
+		chunks = []any{
+			[4]byte{'f', 'm', 't', ' '}, uint32(18), format, uint16(0),
+			[4]byte{'f', 'a', 'c', 't'}, uint32(4), ww.frames,
+		}
+	}
+	chunks = append(chunks, [4]byte{'d', 'a', 't', 'a'}, dataSize)
+
+	size := uint32(4)
+	for _, v := range chunks {
+		size += uint32(binary.Size(v))
+	}
+	header := append([]any{[4]byte{'R', 'I', 'F', 'F'}, size + dataSize, [4]byte{'W', 'A', 'V', 'E'}}, chunks...)
+	for _, v := range header {
+		if err := binary.Write(ww.w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write writes interleaved samples, a whole number of frames. Float files
+// get the samples scaled to [-1, 1).
+func (ww *WavWriter) Write(samples []int16) error {
+	if len(samples)%int(ww.channels) != 0 {
+		return errors.New("partial WAV frame")
+	}
+
+	ww.buf = ww.buf[:0]
+	for _, v := range samples {
+		if ww.format == WAVE_FORMAT_IEEE_FLOAT {
+			ww.buf = binary.LittleEndian.AppendUint32(ww.buf, math.Float32bits(float32(v)/32768))
+		} else {
+			ww.buf = binary.LittleEndian.AppendUint16(ww.buf, uint16(v))
+		}
+	}
+	if _, err := ww.w.Write(ww.buf); err != nil {
+		return err
+	}
+	ww.frames += uint32(len(samples) / int(ww.channels))
+	return nil
+}
+
+// Close fills in the chunk sizes of the header. It does not close the
+// underlying file.
+func (ww *WavWriter) Close() error {
+	if _, err := ww.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ww.writeHeader(); err != nil {
+		return err
+	}
+	_, err := ww.w.Seek(0, io.SeekEnd)
+	return err
+}
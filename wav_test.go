@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wavFile returns a WAV file with the chunks given as ID, size and
+// contents. The size need not match the contents.
+func wavFile(chunks ...any) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF\x00\x00\x00\x00WAVE")
+	for i := 0; i < len(chunks); i += 3 {
+		b.WriteString(chunks[i].(string))
+		binary.Write(&b, binary.LittleEndian, chunks[i+1].(uint32))
+		b.Write(chunks[i+2].([]byte))
+	}
+	return b.Bytes()
+}
+
+// The format chunk of 16-bit PCM mono at 44.1 kHz.
+func pcmFormat() []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, wavFormat{WAVE_FORMAT_PCM, 1, 44100, 88200, 2, 16})
+	return b.Bytes()
+}
+
+func TestReadWavChunkSizes(t *testing.T) {
+	samples := []byte{0x00, 0x40, 0x00, 0xc0}
+
+	// A data chunk claiming 4 GB is read up to the end of the file.
+	wav, err := ReadWav(bytes.NewReader(wavFile("fmt ", uint32(16), pcmFormat(), "data", uint32(0xffffffff), samples)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wav.Samples) != 2 || wav.Samples[0] != 0.5 || wav.Samples[1] != -0.5 {
+		t.Errorf("samples = %v, want [0.5 -0.5]", wav.Samples)
+	}
+
+	// A format chunk claiming 4 GB is refused.
+	if _, err := ReadWav(bytes.NewReader(wavFile("fmt ", uint32(0xffffffff), pcmFormat()))); err == nil {
+		t.Error("format chunk of 4 GB read")
+	}
+
+	// An unknown chunk claiming 4 GB is skipped up to the end of the file.
+	if _, err := ReadWav(bytes.NewReader(wavFile("LIST", uint32(0xffffffff), samples))); err == nil {
+		t.Error("WAV file without data read")
+	}
+}
+
+func TestFloatWavHeader(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), "float.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	w, err := NewFloatWavWriter(file, 44100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]int16{0x4000, -0x8000, 0, 0x7fff, 1, -1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var format bytes.Buffer
+	binary.Write(&format, binary.LittleEndian, wavFormat{WAVE_FORMAT_IEEE_FLOAT, 2, 44100, 352800, 8, 32})
+	format.Write([]byte{0, 0}) // extension size
+	var samples bytes.Buffer
+	for _, v := range []float32{0.5, -1, 0, 32767.0 / 32768, 1.0 / 32768, -1.0 / 32768} {
+		binary.Write(&samples, binary.LittleEndian, math.Float32bits(v))
+	}
+	want := wavFile(
+		"fmt ", uint32(18), format.Bytes(),
+		"fact", uint32(4), []byte{3, 0, 0, 0},
+		"data", uint32(24), samples.Bytes(),
+	)
+	binary.LittleEndian.PutUint32(want[4:], uint32(len(want)-8))
+
+	if !bytes.Equal(data, want) {
+		t.Errorf("float WAV file\n% x\nwant\n% x", data, want)
+	}
+	if _, err := ReadWav(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+}